@@ -0,0 +1,346 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/singleflight"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchWindow  = 2 * time.Millisecond
+	defaultBatchMaxKeys = 100
+)
+
+// A BatchGetter loads many keys in one call. Keys missing from the
+// returned map do not exist if the error is nil, like a Getter returning
+// ErrNotFound, and failed to load with that error otherwise. A Getter
+// passed to NewGroup which also implements BatchGetter is used for the
+// local misses of GetMany
+type BatchGetter interface {
+	GetMany(keys []string) (map[string][]byte, error)
+}
+
+// GetMany is like Get for many keys at once. Missing keys are loaded with
+// one batched request per owner and, for the keys owned by this node, one
+// call of the getter if it implements BatchGetter. Keys which failed to
+// load are missing from the returned map and their errors are joined
+func (g *Group) GetMany(keys []string) (map[string]ByteView, error) {
+	return g.GetManyContext(context.Background(), keys)
+}
+
+// GetManyContext is like GetMany but gives up waiting once ctx is done
+func (g *Group) GetManyContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, errs := g.getMany(ctx, keys)
+	var joined []error
+	for _, key := range keys {
+		if err, ok := errs[key]; ok {
+			joined = append(joined, fmt.Errorf("key %s: %w", key, err))
+			delete(errs, key) // 重复的key只报告一次
+		}
+	}
+	return values, errors.Join(joined...)
+}
+
+// 返回加载成功的值和每个失败的key的错误
+func (g *Group) getMany(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	seen := make(map[string]bool, len(keys))
+	filtered := make(map[string]bool) // 查询过本机布隆过滤器的key
+	var misses []string
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.stats.Gets.Add(1)
+		if val, ok := g.lookupCache(ctx, key); ok {
+			g.stats.CacheHits.Add(1)
+			values[key] = val
+			continue
+		}
+		if g.lookupNotFound(key) {
+			errs[key] = ErrNotFound
+			continue
+		}
+		checked, err := g.admit(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if checked {
+			filtered[key] = true
+		}
+		misses = append(misses, key)
+	}
+	if len(misses) == 0 {
+		return values, errs
+	}
+
+	// 每个key都经过singleflight，正在被其他调用者加载的key不会重复加载
+	g.stats.Loads.Add(int64(len(misses)))
+	results := g.singleLoader.DoMany(ctx, misses, func(ctx context.Context, keys []string) map[string]singleflight.Result {
+		g.stats.LoadsDeduped.Add(int64(len(keys)))
+		results := g.loadMany(ctx, keys)
+		for key, r := range results {
+			g.populateNotFound(key, r.Err)
+		}
+		return results
+	})
+	for key, r := range results {
+		if r.Err != nil {
+			if filtered[key] && errors.Is(r.Err, ErrNotFound) {
+				g.stats.BloomFalsePositives.Add(1)
+			}
+			errs[key] = r.Err
+			continue
+		}
+		values[key] = r.Val.(ByteView)
+	}
+	return values, errs
+}
+
+// 处理远端节点发来的批量请求
+func (g *Group) serveMany(ctx context.Context, keys []string) *pb.BatchResponse {
+	g.stats.ServerRequests.Add(int64(len(keys)))
+	values, errs := g.getMany(ctx, keys)
+	res := &pb.BatchResponse{
+		Values: make(map[string][]byte, len(values)),
+	}
+	for key, value := range values {
+		res.Values[key] = value.ByteSlice()
+	}
+	for key, err := range errs {
+		if errors.Is(err, ErrNotFound) { // 不存在的key单独列出，调用者不需要解析错误信息
+			res.NotFound = append(res.NotFound, key)
+			continue
+		}
+		if res.Errors == nil {
+			res.Errors = make(map[string]string, len(errs))
+		}
+		res.Errors[key] = err.Error()
+	}
+	return res
+}
+
+// 按归属节点对key分组，每个远端节点只发送一个批量请求，
+// 本机负责的key和远端节点加载失败的key在本地加载，其他节点转发来的key全部在本地加载
+func (g *Group) loadMany(ctx context.Context, keys []string) map[string]singleflight.Result {
+	results := make(map[string]singleflight.Result, len(keys))
+	var local []string
+	byPeer := make(map[PeerGetter][]string)
+	for _, key := range keys {
+		if g.peers != nil && !isForwarded(ctx) {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				byPeer[peer] = append(byPeer[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	var mu sync.Mutex // 保护results和local
+	var wg sync.WaitGroup
+	for peer, peerKeys := range byPeer {
+		wg.Add(1)
+		go func(peer PeerGetter, peerKeys []string) {
+			defer wg.Done()
+			values, errs, err := g.getManyFromPeer(ctx, peer, peerKeys)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				g.peerFailed(peer, err)
+				local = append(local, peerKeys...)
+				return
+			}
+			for _, key := range peerKeys {
+				if value, ok := values[key]; ok {
+					g.peerLoaded(key, value)
+					results[key] = singleflight.Result{Val: value}
+					continue
+				}
+				if errors.Is(errs[key], ErrNotFound) { // 归属节点确认key不存在
+					results[key] = singleflight.Result{Err: errs[key]}
+					continue
+				}
+				g.peerFailed(peer, fmt.Errorf("key %s: %w", key, errs[key]))
+				local = append(local, key)
+			}
+		}(peer, peerKeys)
+	}
+	wg.Wait()
+
+	for key, r := range g.loadManyLocally(ctx, local) {
+		results[key] = r
+	}
+	return results
+}
+
+// 向远端节点批量请求keys，返回成功的值和每个失败的key的错误
+func (g *Group) getManyFromPeer(ctx context.Context, peer PeerGetter, keys []string) (map[string]ByteView, map[string]error, error) {
+	req := &pb.BatchRequest{
+		Group:     g.name,
+		Keys:      keys,
+		Forwarded: true, // 由peer自己加载，不再转发
+	}
+	res := &pb.BatchResponse{}
+	err := g.callPeer(ctx, peer, func(ctx context.Context) error {
+		return peer.GetMany(ctx, req, res)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]ByteView, len(res.GetValues()))
+	for key, value := range res.GetValues() {
+		values[key] = ByteView{b: value}
+	}
+	notFound := make(map[string]bool, len(res.GetNotFound()))
+	for _, key := range res.GetNotFound() {
+		notFound[key] = true
+	}
+	errs := make(map[string]error)
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if notFound[key] {
+			errs[key] = ErrNotFound
+		} else if msg, ok := res.GetErrors()[key]; ok {
+			errs[key] = errors.New(msg)
+		} else {
+			errs[key] = errors.New("no value returned by peer")
+		}
+	}
+	return values, errs, nil
+}
+
+// 在本地加载keys，getter实现了BatchGetter时只调用一次
+func (g *Group) loadManyLocally(ctx context.Context, keys []string) map[string]singleflight.Result {
+	results := make(map[string]singleflight.Result, len(keys))
+	if len(keys) == 0 {
+		return results
+	}
+
+	if getter, ok := g.getter.(BatchGetter); ok {
+		g.stats.BatchLoads.Add(1)
+		values, err := getter.GetMany(keys)
+		for _, key := range keys {
+			bytes, ok := values[key]
+			if !ok {
+				keyErr := err
+				if keyErr == nil { // 没有出错也没有返回，说明key不存在
+					keyErr = fmt.Errorf("%w: %s", ErrNotFound, key)
+				}
+				g.localFailed(keyErr)
+				results[key] = singleflight.Result{Err: keyErr}
+				continue
+			}
+			value := ByteView{cloneBytes(bytes)}
+			g.populateCache(key, value)
+			g.localLoaded(key, value)
+			results[key] = singleflight.Result{Val: value}
+		}
+		return results
+	}
+
+	// 没有批量接口时并发地逐个加载
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.getLocally(ctx, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				g.localFailed(err)
+				results[key] = singleflight.Result{Err: err}
+				return
+			}
+			g.localLoaded(key, value)
+			results[key] = singleflight.Result{Val: value}
+		}(key)
+	}
+	wg.Wait()
+	return results
+}
+
+// batcher 将时间窗口内在本机加载的key合并为一次 BatchGetter.GetMany 调用
+type batcher struct {
+	group   *Group
+	getter  BatchGetter
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	pending *pendingBatch // 正在收集key的批次
+}
+
+// pendingBatch 是一次合并的加载，done关闭之后values和err不再修改
+type pendingBatch struct {
+	keys   []string
+	done   chan struct{}
+	values map[string][]byte
+	err    error
+}
+
+func newBatcher(g *Group, getter BatchGetter) *batcher {
+	return &batcher{
+		group:   g,
+		getter:  getter,
+		window:  g.batchWindow,
+		maxKeys: g.batchMaxKeys,
+	}
+}
+
+// get 将key加入当前批次，等待批次加载结束
+// 第一个key开始计时，时间窗口结束或key的数量达到上限时发起加载
+func (b *batcher) get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	p := b.pending
+	if p == nil {
+		p = &pendingBatch{done: make(chan struct{})}
+		b.pending = p
+		time.AfterFunc(b.window, func() { b.flush(p) })
+	}
+	p.keys = append(p.keys, key)
+	full := len(p.keys) >= b.maxKeys
+	b.mu.Unlock()
+	if full {
+		b.flush(p)
+	}
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if value, ok := p.values[key]; ok {
+		return value, nil
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+// 批次还在收集key时结束收集并发起加载，已经发起过的批次不再处理
+func (b *batcher) flush(p *pendingBatch) {
+	b.mu.Lock()
+	if b.pending != p {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+
+	b.group.stats.BatchLoads.Add(1)
+	p.values, p.err = b.getter.GetMany(p.keys)
+	close(p.done)
+}
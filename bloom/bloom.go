@@ -0,0 +1,66 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Filter is a Bloom filter of strings. Has never returns false for a key
+// which was added, and returns true for a key which was not added with
+// about the false positive rate the Filter was sized for.
+// Filter is safe for concurrent use
+type Filter struct {
+	bits []uint64 // 位数组，原子地读写
+	m    uint64   // 位数
+	k    uint64   // 每个key设置的位数
+}
+
+// New creates a Filter sized for n keys at false positive rate p.
+// n < 1 is treated as 1, p outside (0, 1) as 0.01
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	// m = -n*ln(p)/ln(2)^2, k = m/n*ln(2)
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64 // 按uint64对齐
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// Add adds key to the filter
+func (f *Filter) Add(key string) {
+	h1, h2 := hash(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		atomic.OrUint64(&f.bits[bit/64], 1<<(bit%64))
+	}
+}
+
+// Has reports whether key may have been added, false means key was
+// definitely never added
+func (f *Filter) Has(key string) bool {
+	h1, h2 := hash(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 用一个64位的FNV哈希拆出两个哈希值，第i个位置为 h1+i*h2
+func hash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & math.MaxUint32, sum>>32 | 1 // h2为奇数，避免所有位置都相同
+}
@@ -0,0 +1,40 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Has("key" + strconv.Itoa(i)) {
+			t.Fatalf("added key%d is missing", i)
+		}
+	}
+
+	// 没有添加过的key，误判率应该接近0.01
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Has("other" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Fatalf("false positive rate %.4f is too high", rate)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	f := New(0, 2)
+	if f.m == 0 || f.k == 0 {
+		t.Fatalf("expected a usable filter, got m=%d k=%d", f.m, f.k)
+	}
+	f.Add("Tom")
+	if !f.Has("Tom") {
+		t.Fatalf("added key is missing")
+	}
+}
@@ -0,0 +1,198 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for a peer request which was not sent because
+// the circuit breaker of the peer is open
+var ErrCircuitOpen = errors.New("geecache: circuit breaker is open")
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 10 * time.Second
+
+	defaultRetryBaseDelay = 10 * time.Millisecond
+	defaultRetryMaxDelay  = time.Second
+)
+
+// CircuitBreakerOptions configures the per-peer circuit breaker of a
+// Group, see WithCircuitBreaker. Zero values fall back to the defaults
+type CircuitBreakerOptions struct {
+	FailureThreshold int           // 连续失败多少次后断开，默认5
+	OpenTimeout      time.Duration // 断开多久之后放行一个试探请求，默认10s
+}
+
+// RetryPolicy configures how a Group retries a failed peer request, see
+// WithRetry. The n-th retry waits a random duration in
+// [0, min(MaxDelay, BaseDelay*2^(n-1))), so that the retries of many
+// callers do not hit the peer at the same time
+type RetryPolicy struct {
+	MaxAttempts int           // 包括第一次在内最多请求的次数，小于等于1时不重试
+	BaseDelay   time.Duration // 第一次重试前的最长等待时间，默认10ms
+	MaxDelay    time.Duration // 重试前等待时间的上限，默认1s
+}
+
+// 第attempt次重试之前等待的时间，attempt从1开始
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = defaultRetryMaxDelay
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// 等待d，ctx结束时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常放行请求
+	breakerOpen                         // 直接拒绝请求
+	breakerHalfOpen                     // 只放行一个试探请求
+)
+
+// circuitBreaker 记录单个远端节点的请求结果
+// 连续失败达到阈值后断开，断开一段时间后放行一个试探请求，成功则恢复，失败则继续断开
+type circuitBreaker struct {
+	opts CircuitBreakerOptions
+	now  func() time.Time // 获取当前时间，测试时可以替换
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // closed 状态下连续失败的次数
+	openedAt time.Time // 最近一次断开的时间
+	probing  bool      // half-open 状态下试探请求是否正在进行
+}
+
+func newCircuitBreaker(opts CircuitBreakerOptions) *circuitBreaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	return &circuitBreaker{opts: opts, now: time.Now}
+}
+
+// allow 判断是否可以向节点发送请求，返回true时调用者必须调用done或release
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.opts.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// done 记录一次请求的结果
+func (b *circuitBreaker) done(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if ok {
+			b.state = breakerClosed
+			b.failures = 0
+		} else {
+			b.open()
+		}
+		return
+	}
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= b.opts.FailureThreshold {
+		b.open()
+	}
+}
+
+// release 放弃一次没有结果的请求，例如调用者取消了请求
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.state = breakerOpen
+	b.openedAt = b.now()
+	b.failures = 0
+}
+
+// 返回peer的断路器，未开启时返回nil
+// 节点集合变化后不再使用的断路器在创建新断路器时清理
+func (g *Group) breaker(peer PeerGetter) *circuitBreaker {
+	if g.breakerOpts == nil {
+		return nil
+	}
+	peer = breakerKey(peer)
+	g.breakersMu.Lock()
+	defer g.breakersMu.Unlock()
+	if b, ok := g.breakers[peer]; ok {
+		return b
+	}
+	if g.breakers == nil {
+		g.breakers = make(map[PeerGetter]*circuitBreaker)
+	} else if g.peers != nil {
+		current := make(map[PeerGetter]bool)
+		for _, p := range g.peers.GetAll() {
+			current[breakerKey(p)] = true
+		}
+		for p := range g.breakers {
+			if !current[p] {
+				delete(g.breakers, p)
+			}
+		}
+	}
+	b := newCircuitBreaker(*g.breakerOpts)
+	g.breakers[peer] = b
+	return b
+}
+
+// 断路器按底层的getter区分。bounded load 模式下每次重建节点快照都会生成新的
+// loadReportingGetter，而仍在集合中的节点的底层getter不变，断路器的状态得以保留
+func breakerKey(peer PeerGetter) PeerGetter {
+	if g, ok := peer.(*loadReportingGetter); ok {
+		return g.PeerGetter
+	}
+	return peer
+}
@@ -0,0 +1,111 @@
+package geecache
+
+import (
+	"github.com/yuyuyu258963/geeCache/consistenthash"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 3, OpenTimeout: time.Second})
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		if !b.allow() {
+			t.Fatalf("request should be allowed in state %d", b.state)
+		}
+		b.done(false)
+	}
+
+	// 成功的请求会清零连续失败的次数
+	fail()
+	fail()
+	b.allow()
+	b.done(true)
+	fail()
+	fail()
+	if b.state != breakerClosed {
+		t.Fatalf("breaker should stay closed below the threshold")
+	}
+	fail()
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("breaker should open after 3 failures in a row")
+	}
+
+	// 断开一段时间后只放行一个试探请求
+	now = now.Add(time.Second)
+	if !b.allow() || b.state != breakerHalfOpen {
+		t.Fatalf("breaker should let a trial request through after OpenTimeout")
+	}
+	if b.allow() {
+		t.Fatalf("only one trial request is allowed while half-open")
+	}
+	b.done(false)
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("a failed trial should open the breaker again")
+	}
+
+	// 被取消的试探请求不改变状态
+	now = now.Add(time.Second)
+	b.allow()
+	b.release()
+	if b.state != breakerHalfOpen || !b.allow() {
+		t.Fatalf("a released trial should let the next trial through")
+	}
+	b.done(true)
+	if b.state != breakerClosed || !b.allow() {
+		t.Fatalf("a successful trial should close the breaker")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt, max := range []time.Duration{0, 10, 20, 40, 50, 50} {
+		if attempt == 0 {
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(attempt); d < 0 || d >= max*time.Millisecond {
+				t.Fatalf("retry %d: backoff %v out of [0, %v)", attempt, d, max*time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestCircuitBreakerSurvivesRebuild(t *testing.T) {
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.SetPlacement(func() consistenthash.Placement {
+		m := consistenthash.New(defaultReplicas, nil)
+		m.SetBoundedLoad(0.25)
+		return m
+	})
+	a, b := "http://localhost:9001", "http://localhost:9002"
+	pool.Set(a, b)
+	gee := NewGroup("breaker-rebuild", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithCircuitBreaker(CircuitBreakerOptions{}))
+	gee.Register(pool)
+
+	// 找一个归属于a的key
+	var key string
+	var peer PeerGetter
+	for i := 0; peer == nil; i++ {
+		key = "key" + strconv.Itoa(i)
+		if p, ok := pool.PickPeer(key); ok && p.(*loadReportingGetter).peer == a {
+			peer = p
+		}
+	}
+	gee.breaker(peer).open()
+
+	// b被健康检查摘除，节点快照重建，a的getter被重新包装
+	pool.markPeer(b, true)
+	next, ok := pool.PickPeer(key)
+	if !ok || next == peer {
+		t.Fatalf("expected a new wrapper for %s after the rebuild", a)
+	}
+	if cb := gee.breaker(next); cb.state != breakerOpen {
+		t.Fatalf("open breaker of %s was reset by the rebuild", a)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package geecache
 
 // A byteView holds an  immutable view of bytes
 type ByteView struct {
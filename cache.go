@@ -1,9 +1,10 @@
-package main
+package geecache
 
 import (
 	"sync"
+	"time"
 
-	lru "geeCache/lru"
+	lru "github.com/yuyuyu258963/geeCache/lru"
 )
 
 // 在lru的基础上实现了并发访问
@@ -12,26 +13,94 @@ type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64
+	stale      time.Duration // 记录过期之后还保留多久，期间只有getItem能读到
+
+	// 以下计数都在mu的保护下修改
+	nget   int64
+	nhit   int64
+	nevict int64 // 因容量不足或过期被移除的记录数
+}
+
+// CacheStats are returned by stats accessors on Group
+type CacheStats struct {
+	Bytes     int64
+	Items     int64
+	Gets      int64
+	Hits      int64
+	Evictions int64
 }
 
-func (c *cache) add(key string, value ByteView) {
+// ttl <= 0 表示永不过期
+func (c *cache) add(key string, value ByteView, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.lru = lru.New(c.cacheBytes, func(string, lru.Value, lru.EvictReason) {
+			c.nevict++
+		})
 	}
-	c.lru.Add(key, value)
+	c.lru.AddWithStale(key, value, ttl, c.stale)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.nget++
 	if c.lru == nil {
 		return
 	}
 	if v, ok := c.lru.Get(key); ok {
+		c.nhit++
 		return v.(ByteView), ok
 	}
 
 	return
 }
+
+// getItem 与get一样，但也返回还在stale期限内的过期记录，以及记录的时间
+func (c *cache) getItem(key string) (item lru.Item, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nget++
+	if c.lru == nil {
+		return
+	}
+	if item, ok = c.lru.GetItem(key); ok {
+		c.nhit++
+	}
+	return
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	c.lru.Del(key)
+}
+
+// 清理所有已经过期的记录
+func (c *cache) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.RemoveExpired()
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Gets:      c.nget,
+		Hits:      c.nhit,
+		Evictions: c.nevict,
+	}
+	if c.lru != nil {
+		s.Bytes = c.lru.Bytes()
+		s.Items = int64(c.lru.Len())
+	}
+	return s
+}
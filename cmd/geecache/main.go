@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	geecache "github.com/yuyuyu258963/geeCache"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var Tdb = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+	"Sam":  "567",
+	"Ywh":  "555",
+	"jc":   "123",
+}
+
+// 创建本地group
+// 统一都是 scores 分组，不存在的key在10秒内不再查询slow db
+func createGroup() *geecache.Group {
+	return geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := Tdb[key]; ok {
+			fmt.Println("[slow db] load key ", key)
+			time.Sleep(time.Second * 2) // mock slow db load data slowly
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%w: [slow db] %s", geecache.ErrNotFound, key)
+	}), geecache.WithNegativeTTL(10*time.Second))
+}
+
+// 开启一个缓存服务器
+// 使用每个节点的服务端功能
+func startCacheServer(addr string, addrs []string, g *geecache.Group) {
+	peers := geecache.NewHTTPPool(addr) // 创建一个PeerPicker
+	peers.Set(addrs...)                 // 设置一致性哈希中的节点
+	g.Register(peers)                   // 将PeerPicker这个传入到g中，之后Group进行数据查找的时候就可以调用远端节点
+	// 定期探测其他节点，不可用的节点暂时从环上摘除
+	peers.StartHealthCheck(geecache.HealthCheckOptions{
+		OnStateChange: func(peer string, state geecache.PeerState) {
+			log.Printf("peer %s is %s", peer, state)
+		},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", peers)
+	mux.Handle("/metrics", peers.MetricsHandler()) // 供Prometheus抓取的统计信息
+	log.Println("cache is running at ", addr)
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
+}
+
+// 开启一个使用gRPC通信的缓存服务器
+// gRPC的地址不带 http:// 前缀
+func startGRPCCacheServer(addr string, addrs []string, g *geecache.Group) {
+	targets := make([]string, len(addrs))
+	for i, a := range addrs {
+		targets[i] = a[7:]
+	}
+	peers := geecache.NewGRPCPool(addr[7:])
+	if err := peers.Set(targets...); err != nil {
+		log.Fatal(err)
+	}
+	g.Register(peers)
+
+	lis, err := net.Listen("tcp", addr[7:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer()
+	peers.RegisterServer(server)
+	log.Println("grpc cache is running at ", addr[7:])
+	log.Fatal(server.Serve(lis))
+}
+
+// 提供了一个类似于服务器的作用
+func startAPIServer(apiAddr string, g *geecache.Group) {
+	http.Handle("/api", http.HandlerFunc( // 监听api这个路由下的
+		func(w http.ResponseWriter, r *http.Request) {
+			key := r.URL.Query().Get("key")
+			view, err := g.GetContext(r.Context(), key)
+			if errors.Is(err, geecache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(view.ByteSlice())
+		},
+	))
+
+	log.Println("fontend server is running at ", apiAddr)
+	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+}
+
+func serve() {
+	// 获取运行指定参数
+	var port int
+	var api bool
+	var transport string
+	flag.IntVar(&port, "port", 8002, "server port, one of 7998, 8002 and 8003")
+	flag.BoolVar(&api, "api", false, "start a api server")
+	flag.StringVar(&transport, "transport", "http", "peer transport: http or grpc")
+	flag.Parse()
+
+	apiAddr := "http://localhost:9999"
+	addrMap := map[int]string{ // 远端节点组
+		7998: "http://localhost:7998",
+		8002: "http://localhost:8002",
+		8003: "http://localhost:8003",
+	}
+
+	addr, ok := addrMap[port]
+	if !ok { // 只能使用节点组中的端口
+		log.Fatalf("unknown port %d, expected one of 7998, 8002 and 8003", port)
+	}
+
+	var addrs []string
+	for _, v := range addrMap {
+		addrs = append(addrs, v)
+	}
+	g := createGroup()
+	if api {
+		go startAPIServer(apiAddr, g)
+	}
+
+	if transport == "grpc" {
+		startGRPCCacheServer(addr, addrs, g)
+		return
+	}
+	startCacheServer(addr, []string(addrs), g)
+}
+
+func main() {
+	serve()
+}
@@ -0,0 +1,99 @@
+package consistenthash
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// SetBoundedLoad turns on consistent hashing with bounded loads (Mirrokni,
+// Thorup and Zadimoghaddam): no node takes more than (1+epsilon) times the
+// average in-flight load, Get walks the ring past the saturated nodes.
+// Callers report the load of the node Get returned with Inc and Done.
+// Inc, Done and Get are safe to call concurrently, an epsilon <= 0 turns
+// the mode off
+func (m *Map) SetBoundedLoad(epsilon float64) {
+	if epsilon <= 0 {
+		m.epsilon, m.loads = 0, nil
+		atomic.StoreInt64(&m.totalLoad, 0)
+		return
+	}
+	m.epsilon = epsilon
+	if m.loads == nil {
+		m.loads = make(map[string]*int64, len(m.nodes))
+		for node := range m.nodes {
+			m.loads[node] = new(int64)
+		}
+	}
+}
+
+// Inc records that node started handling a request
+func (m *Map) Inc(node string) {
+	if l, ok := m.loads[node]; ok {
+		atomic.AddInt64(l, 1)
+		atomic.AddInt64(&m.totalLoad, 1)
+	}
+}
+
+// Done records that node finished handling a request
+func (m *Map) Done(node string) {
+	if l, ok := m.loads[node]; ok {
+		atomic.AddInt64(l, -1)
+		atomic.AddInt64(&m.totalLoad, -1)
+	}
+}
+
+// Loads returns the in-flight load of every node, nil if the bounded
+// load mode is off
+func (m *Map) Loads() map[string]int64 {
+	if m.loads == nil {
+		return nil
+	}
+	loads := make(map[string]int64, len(m.loads))
+	for node, l := range m.loads {
+		loads[node] = atomic.LoadInt64(l)
+	}
+	return loads
+}
+
+// MaxLoad returns the load a node may take before Get skips it, that is
+// ceil((1+epsilon) * (total+1) / nodes), weighted nodes get a proportional
+// share. It returns 0 if the bounded load mode is off
+func (m *Map) MaxLoad(node string) int64 {
+	if m.loads == nil || m.weights == 0 {
+		return 0
+	}
+	return m.maxLoad(node, atomic.LoadInt64(&m.totalLoad))
+}
+
+func (m *Map) maxLoad(node string, totalLoad int64) int64 {
+	// 加上即将分配的这个请求，保证容量至少为1
+	avg := float64(totalLoad+1) * float64(m.nodes[node]) / float64(m.weights)
+	return int64(math.Ceil(avg * (1 + m.epsilon)))
+}
+
+// 从环上的下标start开始顺时针寻找第一个未满载的节点，都满载时返回start对应的节点
+func (m *Map) leastLoaded(start int) string {
+	totalLoad := atomic.LoadInt64(&m.totalLoad)
+	checked := make(map[string]bool, len(m.nodes))
+	for i := 0; i < len(m.keys) && len(checked) < len(m.nodes); i++ {
+		node := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if checked[node] {
+			continue
+		}
+		checked[node] = true
+		if atomic.LoadInt64(m.loads[node])+1 <= m.maxLoad(node, totalLoad) {
+			return node
+		}
+	}
+	return m.hashMap[m.keys[start]]
+}
+
+// 删除已经不在环上的节点的负载计数
+func (m *Map) pruneLoads() {
+	for node, l := range m.loads {
+		if _, ok := m.nodes[node]; !ok {
+			atomic.AddInt64(&m.totalLoad, -atomic.LoadInt64(l))
+			delete(m.loads, node)
+		}
+	}
+}
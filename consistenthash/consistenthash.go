@@ -10,11 +10,20 @@ import (
 type Hash func(data []byte) uint32
 
 // Map contains all hashed keys
+// Map is not safe for concurrent modification, callers which update it
+// while reading should build a new Map and swap it in
 type Map struct {
 	hash     Hash           // hash function
 	replicas int            //虚拟节点倍数
 	keys     []int          //存储所有虚拟节点映射到的key，sorted
 	hashMap  map[int]string // 虚拟节点到真是节点名称的映射
+	nodes    map[string]int // 已经添加的真实节点及其权重
+	weights  int            // 所有真实节点的权重之和
+
+	// bounded load 模式，见 SetBoundedLoad
+	epsilon   float64           // 每个节点的容量为平均负载的 (1+epsilon) 倍，0表示不限制
+	loads     map[string]*int64 // 每个节点正在处理的请求数，原子地读写
+	totalLoad int64             // 所有节点正在处理的请求数之和，原子地读写
 }
 
 // New Create a Map instance
@@ -24,6 +33,7 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		keys:     make([]int, 0),
 		hashMap:  make(map[int]string),
+		nodes:    make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE // 循环冗余校验码
@@ -32,16 +42,103 @@ func New(replicas int, fn Hash) *Map {
 }
 
 // Add adds some keys to the hash
+// 已经存在的节点会被忽略，不会重复添加虚拟节点
 func (m *Map) Add(keys ...string) {
 	for _, k := range keys {
-		for i := 0; i < m.replicas; i++ {
-			// hs := int(m.hash([]byte(strconv.Itoa(i) + k)))
-			hs := int(m.hash([]byte(k + "::" + strconv.Itoa(i))))
-			m.keys = append(m.keys, hs)
-			m.hashMap[hs] = k // 虚拟节点到真实节点的映射
+		m.addNode(k, 1)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeighted adds node with weight*replicas virtual nodes, so that its
+// share of the keys grows with its capacity. A weight < 1 is treated as 1
+func (m *Map) AddWeighted(node string, weight int) {
+	m.addNode(node, weight)
+	sort.Ints(m.keys)
+}
+
+// 添加节点的虚拟节点，调用者负责排序m.keys
+func (m *Map) addNode(node string, weight int) {
+	if _, ok := m.nodes[node]; ok {
+		return
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	m.nodes[node] = weight
+	m.weights += weight
+	if m.loads != nil && m.loads[node] == nil {
+		m.loads[node] = new(int64)
+	}
+	for i := 0; i < m.replicas*weight; i++ {
+		hs := int(m.hash([]byte(node + "::" + strconv.Itoa(i))))
+		m.keys = append(m.keys, hs)
+		m.hashMap[hs] = node // 虚拟节点到真实节点的映射
+	}
+}
+
+// Remove removes some nodes and their virtual nodes from the hash
+func (m *Map) Remove(nodes ...string) {
+	removed := false
+	for _, node := range nodes {
+		if _, ok := m.nodes[node]; ok {
+			delete(m.nodes, node)
+			removed = true
 		}
 	}
+	if removed {
+		m.rebuild()
+	}
+}
+
+// Set replaces the nodes of the hash with nodes,
+// calling it again with the same nodes changes nothing
+func (m *Map) Set(nodes ...string) {
+	m.nodes = make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		m.nodes[node] = 1
+	}
+	m.rebuild()
+}
+
+// SetWeighted is like Set but every node carries its own weight
+func (m *Map) SetWeighted(weights map[string]int) {
+	m.nodes = normalizeWeights(weights)
+	m.rebuild()
+}
+
+// Weight returns the weight of node, 0 if it is not in the hash
+func (m *Map) Weight(node string) int {
+	return m.nodes[node]
+}
+
+// Nodes returns the real nodes in the hash, sorted
+func (m *Map) Nodes() []string {
+	return sortedNodes(m.nodes)
+}
+
+// 根据当前的真实节点重新生成所有虚拟节点
+// 按节点名排序后添加，保证哈希冲突时的结果与添加顺序无关
+func (m *Map) rebuild() {
+	weights := m.nodes
+	m.keys = make([]int, 0, len(weights)*m.replicas)
+	m.hashMap = make(map[int]string, len(weights)*m.replicas)
+	m.nodes = make(map[string]int, len(weights))
+	m.weights = 0
+	for _, node := range sortedNodes(weights) {
+		m.addNode(node, weights[node])
+	}
 	sort.Ints(m.keys)
+	m.pruneLoads()
+}
+
+func sortedNodes(weights map[string]int) []string {
+	nodes := make([]string, 0, len(weights))
+	for node := range weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
 }
 
 // get the closet item int the hash to the provided key
@@ -51,9 +148,42 @@ func (m *Map) Get(key string) string {
 		return ""
 	}
 
+	l := m.search(key)
+	if m.loads != nil { // bounded load 模式下跳过已经满载的节点
+		return m.leastLoaded(l)
+	}
+	return m.hashMap[m.keys[l]]
+}
+
+// GetN returns up to n distinct real nodes for key in ring order, the
+// first one is the node Get would return without bounded loads and the
+// others are the backups which take over when it leaves
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+
+	start := m.search(key)
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; len(nodes) < n; i++ { // 顺时针跳过已经选过的真实节点
+		node := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 返回key落在环上的第一个虚拟节点的下标
+func (m *Map) search(key string) int {
 	hs := int(m.hash([]byte(key)))
 	if hs > m.keys[len(m.keys)-1] {
-		return m.hashMap[m.keys[0]]
+		return 0
 	}
 	l := 0
 	r := len(m.keys) - 1
@@ -65,6 +195,5 @@ func (m *Map) Get(key string) string {
 			l = mid + 1
 		}
 	}
-
-	return m.hashMap[m.keys[l]]
+	return l
 }
@@ -1,15 +1,27 @@
 package consistenthash
 
 import (
+	"crypto/sha1"
+	"encoding/binary"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
+// numberHash 将key当作数字，虚拟节点 "node::i" 当作数字 i 后接 node，
+// 例如节点2的虚拟节点为 2、12、22
+func numberHash(data []byte) uint32 {
+	s := string(data)
+	if node, i, ok := strings.Cut(s, "::"); ok {
+		s = i + node
+	}
+	n, _ := strconv.Atoi(s)
+	return uint32(n)
+}
+
 func TestMap(t *testing.T) {
-	m := New(3, func(data []byte) uint32 {
-		i, _ := strconv.Atoi(string(data))
-		return uint32(i)
-	})
+	m := New(3, numberHash)
 
 	testCase := map[string]string{
 		"2":  "2",
@@ -33,3 +45,143 @@ func TestMap(t *testing.T) {
 		}
 	}
 }
+
+func TestRemoveAndSet(t *testing.T) {
+	m := New(3, numberHash)
+	m.Add("2", "4", "6")
+	m.Add("2") // 重复添加不应产生重复的虚拟节点
+	if len(m.keys) != 9 {
+		t.Fatalf("expected 9 virtual nodes, got %d", len(m.keys))
+	}
+
+	m.Remove("4")
+	if mk := m.Get("23"); mk != "6" {
+		t.Errorf("after remove expected real node 6, got %v", mk)
+	}
+	if len(m.keys) != 6 || len(m.hashMap) != 6 {
+		t.Fatalf("virtual nodes of 4 were not removed: %v", m.keys)
+	}
+
+	m.Set("4", "9")
+	m.Set("4", "9")
+	testCase := map[string]string{
+		"2":  "4",
+		"23": "4",
+		"27": "9",
+		"30": "4",
+	}
+	for k, v := range testCase {
+		if mk := m.Get(k); mk != v {
+			t.Errorf("after set expected real node %v, got %v", v, mk)
+		}
+	}
+	if len(m.keys) != 6 {
+		t.Fatalf("expected 6 virtual nodes after set, got %d", len(m.keys))
+	}
+	if nodes := m.Nodes(); len(nodes) != 2 || nodes[0] != "4" || nodes[1] != "9" {
+		t.Fatalf("unexpected nodes %v", nodes)
+	}
+}
+
+func TestWeightedShare(t *testing.T) {
+	m := New(200, sha1Hash)
+	weights := map[string]int{"8G-a": 1, "8G-b": 1, "64G": 8}
+	for node, w := range weights {
+		m.AddWeighted(node, w)
+	}
+	if len(m.keys) != 200*10 {
+		t.Fatalf("expected %d virtual nodes, got %d", 200*10, len(m.keys))
+	}
+
+	const total = 100000
+	counts := make(map[string]int)
+	for i := 0; i < total; i++ {
+		counts[m.Get("key-"+strconv.Itoa(i))]++
+	}
+
+	// 每个节点分到的key的比例应与权重成正比，允许一定误差
+	const tolerance = 0.3
+	for node, w := range weights {
+		expect := float64(total) * float64(w) / 10
+		if got := float64(counts[node]); got < expect*(1-tolerance) || got > expect*(1+tolerance) {
+			t.Errorf("node %s (weight %d) got %v keys, expected about %v", node, w, got, expect)
+		}
+	}
+	t.Logf("key share: %v", counts)
+
+	m.SetWeighted(map[string]int{"8G-a": 1, "64G": 8})
+	if m.Weight("64G") != 8 || m.Weight("8G-b") != 0 || len(m.keys) != 200*9 {
+		t.Fatalf("SetWeighted did not replace the nodes: %v", m.nodes)
+	}
+	m.Remove("8G-a")
+	if len(m.keys) != 200*8 {
+		t.Fatalf("weighted node lost its virtual nodes after remove, got %d", len(m.keys))
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	m := New(3, numberHash)
+	m.Add("2", "4", "6")
+	m.SetBoundedLoad(0.25)
+
+	// "11" 落在节点2上，节点2满载后顺时针找到下一个节点4
+	if mk := m.Get("11"); mk != "2" {
+		t.Fatalf("expected real node 2, got %v", mk)
+	}
+	m.Inc("2")
+	if max := m.MaxLoad("2"); max != 1 {
+		t.Fatalf("expected max load 1 with one request in flight, got %d", max)
+	}
+	if mk := m.Get("11"); mk != "4" {
+		t.Fatalf("expected saturated node 2 to be skipped for 4, got %v", mk)
+	}
+	m.Inc("4")
+	m.Inc("6")
+	// 所有节点负载相同时容量随之增长，不会一直跳过
+	if mk := m.Get("11"); mk != "2" {
+		t.Fatalf("expected real node 2 once loads are even, got %v", mk)
+	}
+
+	m.Done("2")
+	m.Remove("6")
+	if loads := m.Loads(); len(loads) != 2 || loads["2"] != 0 || loads["4"] != 1 {
+		t.Fatalf("unexpected loads after remove %v", loads)
+	}
+	if m.totalLoad != 1 {
+		t.Fatalf("removed node's load should be dropped, total %d", m.totalLoad)
+	}
+
+	m.SetBoundedLoad(0)
+	m.Inc("2")
+	if m.Loads() != nil {
+		t.Fatalf("loads should not be tracked when bounded load is off")
+	}
+}
+
+func TestGetN(t *testing.T) {
+	m := New(3, numberHash)
+	m.Add("2", "4", "6")
+
+	testCase := map[string][]string{
+		"11": {"2", "4", "6"}, // 12(2) 14(4) 16(6)
+		"23": {"4", "6", "2"}, // 24(4) 26(6) 2(2)
+		"27": {"2", "4", "6"}, // 绕回环的起点
+	}
+	for k, v := range testCase {
+		if got := m.GetN(k, 3); !reflect.DeepEqual(got, v) {
+			t.Errorf("GetN(%s, 3) expected %v, got %v", k, v, got)
+		}
+		if got := m.GetN(k, 10); len(got) != 3 {
+			t.Errorf("GetN(%s, 10) should stop at the 3 real nodes, got %v", k, got)
+		}
+		if got := m.GetN(k, 1); got[0] != m.Get(k) {
+			t.Errorf("the first of GetN(%s) should be Get(%s), got %v", k, k, got)
+		}
+	}
+}
+
+// sha1Hash 分布均匀，只相差一个字符的节点名也能分散开
+func sha1Hash(data []byte) uint32 {
+	sum := sha1.Sum(data)
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,56 @@
+package consistenthash
+
+// Jump places keys with Jump Consistent Hash (Lamping & Veach).
+// It needs no memory besides the node list and spreads keys evenly, but
+// only a node appended at the end of the sorted node list moves the minimal
+// amount of keys; a node joining or leaving in the middle remaps more.
+// A node with weight w takes w buckets
+type Jump struct {
+	nodes   map[string]int // 节点及其权重
+	buckets []string       // 每个桶对应的节点，按节点名排序后依次展开
+}
+
+// NewJump creates an empty Jump placement
+func NewJump() *Jump {
+	return &Jump{nodes: make(map[string]int)}
+}
+
+// SetWeighted implements Placement
+func (j *Jump) SetWeighted(weights map[string]int) {
+	j.nodes = normalizeWeights(weights)
+	j.buckets = j.buckets[:0]
+	for _, node := range sortedNodes(j.nodes) {
+		for i := 0; i < j.nodes[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+// Get implements Placement
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(hash64(key), len(j.buckets))]
+}
+
+// Nodes implements Placement
+func (j *Jump) Nodes() []string {
+	return sortedNodes(j.nodes)
+}
+
+// Weight implements Placement
+func (j *Jump) Weight(node string) int {
+	return j.nodes[node]
+}
+
+// 论文中的算法，返回 [0, numBuckets) 中的一个桶
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,108 @@
+package consistenthash
+
+// DefaultMaglevTableSize is the lookup table size used when NewMaglev is
+// given a size <= 0. It should be a prime much larger than the node count
+const DefaultMaglevTableSize = 65537
+
+// Maglev places keys with the lookup table from Google's Maglev load
+// balancer: every node fills the table following its own permutation, so
+// a lookup is one hash and one index and the table stays nearly balanced.
+// A node leaving moves slightly more keys than the minimum.
+// A node with weight w fills w slots in each round
+type Maglev struct {
+	size  uint64         // 查找表大小，需为质数
+	nodes map[string]int // 节点及其权重
+	names []string       // 排好序的节点
+	table []int          // 查找表，值为 names 的下标
+}
+
+// NewMaglev creates an empty Maglev placement with a lookup table of size
+// entries. A size which is not a prime is rounded up to the next prime,
+// otherwise the permutations could not reach every entry of the table
+func NewMaglev(size int) *Maglev {
+	if size <= 0 {
+		size = DefaultMaglevTableSize
+	}
+	return &Maglev{size: nextPrime(uint64(size)), nodes: make(map[string]int)}
+}
+
+// 返回不小于n的最小质数
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := uint64(2); d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// SetWeighted implements Placement and rebuilds the lookup table
+func (m *Maglev) SetWeighted(weights map[string]int) {
+	m.nodes = normalizeWeights(weights)
+	m.names = sortedNodes(m.nodes)
+	m.table = nil
+	if len(m.names) == 0 {
+		return
+	}
+
+	// 每个节点的排列为 (offset + j*skip) % size
+	offsets := make([]uint64, len(m.names))
+	skips := make([]uint64, len(m.names))
+	next := make([]uint64, len(m.names))
+	for i, node := range m.names {
+		h := hash64(node)
+		offsets[i] = h % m.size
+		skips[i] = mix64(h)%(m.size-1) + 1
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	var filled uint64
+	for {
+		for i, node := range m.names {
+			for w := 0; w < m.nodes[node]; w++ {
+				c := (offsets[i] + next[i]*skips[i]) % m.size
+				for table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % m.size
+				}
+				table[c] = i
+				next[i]++
+				filled++
+				if filled == m.size {
+					m.table = table
+					return
+				}
+			}
+		}
+	}
+}
+
+// Get implements Placement
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.names[m.table[hash64(key)%m.size]]
+}
+
+// Nodes implements Placement
+func (m *Maglev) Nodes() []string {
+	return append([]string(nil), m.names...)
+}
+
+// Weight implements Placement
+func (m *Maglev) Weight(node string) int {
+	return m.nodes[node]
+}
@@ -0,0 +1,77 @@
+package consistenthash
+
+// Placement decides which node owns a key. Implementations are not safe
+// for concurrent modification, like Map they are meant to be rebuilt and
+// swapped in when the nodes change
+type Placement interface {
+	// Get returns the node which owns key, "" if there is no node
+	Get(key string) string
+	// SetWeighted replaces the nodes with weights, a weight < 1 is treated as 1
+	SetWeighted(weights map[string]int)
+	// Nodes returns the nodes, sorted
+	Nodes() []string
+	// Weight returns the weight of node, 0 if it is not a node
+	Weight(node string) int
+}
+
+// LoadReporter is implemented by placements which take the in-flight load
+// of the nodes into account, the pools call Inc before and Done after each
+// request to the node picked for a key
+type LoadReporter interface {
+	Inc(node string)
+	Done(node string)
+}
+
+// MultiPlacement is implemented by placements which can name the backup
+// owners of a key
+type MultiPlacement interface {
+	Placement
+	// GetN returns up to n distinct nodes for key in preference order,
+	// the first one is the node Get returns when it ignores loads
+	GetN(key string, n int) []string
+}
+
+var (
+	_ LoadReporter = (*Map)(nil)
+
+	_ MultiPlacement = (*Map)(nil)
+	_ MultiPlacement = (*Rendezvous)(nil)
+
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// 将节点权重规范化，小于1的权重按1处理
+func normalizeWeights(weights map[string]int) map[string]int {
+	nodes := make(map[string]int, len(weights))
+	for node, weight := range weights {
+		if weight < 1 {
+			weight = 1
+		}
+		nodes[node] = weight
+	}
+	return nodes
+}
+
+// hash64 是 64 位的 FNV-1a 再经过 splitmix64 的混淆，
+// jump、rendezvous 和 maglev 都需要分布均匀的 64 位哈希
+func hash64(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return mix64(h)
+}
+
+// splitmix64 的收尾函数
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,196 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"jump", func() Placement { return NewJump() }},
+	{"rendezvous", func() Placement { return NewRendezvous() }},
+	{"maglev", func() Placement { return NewMaglev(0) }},
+}
+
+func nodeWeights(n int) map[string]int {
+	weights := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		weights[fmt.Sprintf("http://10.0.0.%d:8001", i)] = 1
+	}
+	return weights
+}
+
+// 统计每个key的归属节点
+func owners(p Placement, keys int) []string {
+	res := make([]string, keys)
+	for i := range res {
+		res[i] = p.Get("key-" + strconv.Itoa(i))
+	}
+	return res
+}
+
+// 返回各节点分到的key数量相对于平均值的最大偏差
+func maxSkew(owned []string, nodes int) float64 {
+	counts := make(map[string]int)
+	for _, o := range owned {
+		counts[o]++
+	}
+	avg := float64(len(owned)) / float64(nodes)
+	skew := 0.0
+	for _, c := range counts {
+		skew = math.Max(skew, math.Abs(float64(c)-avg)/avg)
+	}
+	return skew
+}
+
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+// TestPlacementReport compares how evenly the placements spread keys and
+// how many keys move when a node joins or leaves, run with -v to see it
+func TestPlacementReport(t *testing.T) {
+	const keys, nodes = 100000, 10
+	for _, pc := range placements {
+		weights := nodeWeights(nodes)
+		p := pc.new()
+		p.SetWeighted(weights)
+		base := owners(p, keys)
+
+		joined := nodeWeights(nodes + 1)
+		p.SetWeighted(joined)
+		afterJoin := owners(p, keys)
+
+		left := nodeWeights(nodes)
+		delete(left, "http://10.0.0.3:8001")
+		p.SetWeighted(left)
+		afterLeave := owners(p, keys)
+
+		skew := maxSkew(base, nodes)
+		t.Logf("%-10s max skew %5.1f%%  moved on join %5.1f%% (ideal %4.1f%%)  moved on leave %5.1f%% (ideal %4.1f%%)",
+			pc.name, skew*100, moved(base, afterJoin)*100, 100.0/(nodes+1), moved(base, afterLeave)*100, 100.0/nodes)
+
+		if skew > 0.5 {
+			t.Errorf("%s spreads keys too unevenly: max skew %.2f", pc.name, skew)
+		}
+		// 环和rendezvous在节点离开时只移动它拥有的key，maglev接近这个下限
+		// jump只有在最后一个桶离开时才是如此，这里不做检查
+		switch pc.name {
+		case "ring", "rendezvous":
+			for i := range base {
+				if base[i] != "http://10.0.0.3:8001" && base[i] != afterLeave[i] {
+					t.Fatalf("%s moved key-%d which was not owned by the leaving node", pc.name, i)
+				}
+			}
+		case "maglev":
+			if m := moved(base, afterLeave); m > 2.0/nodes {
+				t.Errorf("%s moved %.2f of the keys when one of %d nodes left", pc.name, m, nodes)
+			}
+		}
+	}
+}
+
+func TestPlacementWeights(t *testing.T) {
+	for _, pc := range placements {
+		p := pc.new()
+		p.SetWeighted(map[string]int{"a": 1, "b": 3, "c": 0})
+		if p.Weight("b") != 3 || p.Weight("c") != 1 || p.Weight("d") != 0 {
+			t.Fatalf("%s: unexpected weights", pc.name)
+		}
+		if nodes := p.Nodes(); len(nodes) != 3 || nodes[0] != "a" {
+			t.Fatalf("%s: unexpected nodes %v", pc.name, nodes)
+		}
+
+		counts := make(map[string]int)
+		for _, o := range owners(p, 50000) {
+			counts[o]++
+		}
+		// b 的权重是 a 的3倍，分到的key也应大约是3倍
+		if ratio := float64(counts["b"]) / float64(counts["a"]); ratio < 2 || ratio > 4 {
+			t.Errorf("%s: weight 3 vs 1 got ratio %.2f (%v)", pc.name, ratio, counts)
+		}
+
+		p.SetWeighted(nil)
+		if p.Get("key") != "" {
+			t.Fatalf("%s: empty placement should return no node", pc.name)
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	for _, nodes := range []int{10, 100} {
+		for _, pc := range placements {
+			p := pc.new()
+			p.SetWeighted(nodeWeights(nodes))
+			b.Run(fmt.Sprintf("%s/%d", pc.name, nodes), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i&1023])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPlacementSet(b *testing.B) {
+	for _, pc := range placements {
+		weights := nodeWeights(100)
+		b.Run(pc.name, func(b *testing.B) {
+			p := pc.new()
+			for i := 0; i < b.N; i++ {
+				p.SetWeighted(weights)
+			}
+		})
+	}
+}
+
+func TestRendezvousGetN(t *testing.T) {
+	r := NewRendezvous()
+	r.SetWeighted(nodeWeights(5))
+	for i := 0; i < 100; i++ {
+		key := "key-" + strconv.Itoa(i)
+		nodes := r.GetN(key, 3)
+		if len(nodes) != 3 || nodes[0] != r.Get(key) {
+			t.Fatalf("GetN(%s) = %v, the first should be %s", key, nodes, r.Get(key))
+		}
+		// 主节点离开后，原来的第二顺位成为新的主节点
+		rest := nodeWeights(5)
+		delete(rest, nodes[0])
+		after := NewRendezvous()
+		after.SetWeighted(rest)
+		if after.Get(key) != nodes[1] {
+			t.Fatalf("%s: expected backup %s to take over, got %s", key, nodes[1], after.Get(key))
+		}
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	// 不是质数或太小的表也要能建立，不能死循环或panic
+	for _, size := range []int{1, 2, 4, 100} {
+		m := NewMaglev(size)
+		m.SetWeighted(map[string]int{"a": 1, "b": 1, "c": 1, "d": 1})
+		if m.size < uint64(size) || nextPrime(m.size) != m.size {
+			t.Fatalf("NewMaglev(%d) has table size %d, expected a prime >= %d", size, m.size, size)
+		}
+		if node := m.Get("Tom"); node == "" {
+			t.Fatalf("NewMaglev(%d) placed Tom nowhere", size)
+		}
+	}
+	if got := NewMaglev(100).size; got != 101 {
+		t.Fatalf("expected 100 rounded up to 101, got %d", got)
+	}
+}
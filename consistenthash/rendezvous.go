@@ -0,0 +1,89 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous places keys with Highest Random Weight hashing: every node
+// scores the key and the highest score wins. A node leaving only moves the
+// keys it owned, at the cost of O(nodes) work per lookup.
+// Weights use the logarithmic method, score = -weight / ln(u)
+type Rendezvous struct {
+	nodes  map[string]int // 节点及其权重
+	names  []string       // 排好序的节点
+	hashes []uint64       // names[i] 的哈希，预先计算
+	scales []float64      // names[i] 的权重
+}
+
+// NewRendezvous creates an empty Rendezvous placement
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{nodes: make(map[string]int)}
+}
+
+// SetWeighted implements Placement
+func (r *Rendezvous) SetWeighted(weights map[string]int) {
+	r.nodes = normalizeWeights(weights)
+	r.names = sortedNodes(r.nodes)
+	r.hashes = make([]uint64, len(r.names))
+	r.scales = make([]float64, len(r.names))
+	for i, node := range r.names {
+		r.hashes[i] = hash64(node)
+		r.scales[i] = float64(r.nodes[node])
+	}
+}
+
+// Get implements Placement
+func (r *Rendezvous) Get(key string) string {
+	if len(r.names) == 0 {
+		return ""
+	}
+	kh := hash64(key)
+	best, bestScore := 0, math.Inf(-1)
+	for i := range r.hashes {
+		if score := r.score(kh, i); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return r.names[best]
+}
+
+// GetN implements MultiPlacement, the nodes are ordered by their score
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	kh := hash64(key)
+	scores := make([]float64, len(r.names))
+	order := make([]int, len(r.names))
+	for i := range r.names {
+		scores[i] = r.score(kh, i)
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	if n > len(order) {
+		n = len(order)
+	}
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = r.names[order[i]]
+	}
+	return nodes
+}
+
+// 节点i对key的得分
+func (r *Rendezvous) score(kh uint64, i int) float64 {
+	// 将 key 和节点的组合哈希映射到 (0, 1) 上的均匀分布
+	u := (float64(mix64(kh^r.hashes[i])>>11) + 0.5) / (1 << 53)
+	return -r.scales[i] / math.Log(u)
+}
+
+// Nodes implements Placement
+func (r *Rendezvous) Nodes() []string {
+	return append([]string(nil), r.names...)
+}
+
+// Weight implements Placement
+func (r *Rendezvous) Weight(node string) int {
+	return r.nodes[node]
+}
@@ -0,0 +1,72 @@
+package geecache
+
+import (
+	"fmt"
+	"github.com/yuyuyu258963/geeCache/bloom"
+	"log"
+)
+
+const (
+	defaultBloomExpectedKeys      = 100000
+	defaultBloomFalsePositiveRate = 0.01
+)
+
+// BloomFilterOptions configures the Bloom filter of existing keys of a
+// Group, see WithBloomFilter. Zero values fall back to the defaults
+type BloomFilterOptions struct {
+	ExpectedKeys      int     // 预计的key的数量，默认100000，超出后误判率上升
+	FalsePositiveRate float64 // 不存在的key通过过滤器的概率，默认0.01
+
+	// Seed is called once by NewGroup to add every key which exists in the
+	// data source, it is required. If it returns an error the filter is not
+	// used, since an incomplete filter would reject existing keys
+	Seed func(add func(key string)) error
+}
+
+// 创建并填充过滤器，填充失败时不使用过滤器
+func (g *Group) initFilter(opts BloomFilterOptions) {
+	if opts.ExpectedKeys <= 0 {
+		opts.ExpectedKeys = defaultBloomExpectedKeys
+	}
+	if opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+		opts.FalsePositiveRate = defaultBloomFalsePositiveRate
+	}
+	f := bloom.New(opts.ExpectedKeys, opts.FalsePositiveRate)
+	if err := opts.Seed(f.Add); err != nil {
+		log.Printf("[GeeCache] group %s: seed bloom filter: %v, filter disabled", g.name, err)
+		return
+	}
+	g.filter = f
+}
+
+// AddToBloomFilter records keys which were created in the data source
+// without going through Set, so that the Bloom filter of the Group does
+// not reject them. Call it on every node, since the owner of a key changes
+// with the peers. It does nothing without a filter
+func (g *Group) AddToBloomFilter(keys ...string) {
+	if g.filter == nil {
+		return
+	}
+	for _, key := range keys {
+		g.filter.Add(key)
+	}
+}
+
+// 本机是归属节点且过滤器确定key不存在时返回 ErrNotFound，不再调用getter
+// 其他节点的key由归属节点判断：只有归属节点一定见过通过Set写入的新key
+// checked 表示是否查询了本机的过滤器，只有这时不存在的key才算作误判
+func (g *Group) admit(key string) (checked bool, err error) {
+	if g.filter == nil {
+		return false, nil
+	}
+	if g.peers != nil {
+		if _, ok := g.pickOwner(key); ok {
+			return false, nil
+		}
+	}
+	if g.filter.Has(key) {
+		return true, nil
+	}
+	g.stats.BloomRejects.Add(1)
+	return true, fmt.Errorf("%w: %s", ErrNotFound, key)
+}
@@ -1,10 +1,17 @@
-package main
+package geecache
 
 import (
-	pb "geeCache/cachepb"
-	"geeCache/singleflight"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yuyuyu258963/geeCache/bloom"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/singleflight"
 	"log"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 )
 
 /**
@@ -16,11 +23,17 @@ import (
                             |-----> 调用`回调函数`，获取值并添加到缓存 --> 返回缓存值 ⑶
 */
 
-// A Getter loads data for a key
+// A Getter loads data for a key. It returns an error wrapping ErrNotFound
+// when the key does not exist, so that the miss can be cached
 type Getter interface {
 	Get(string) ([]byte, error)
 }
 
+// ErrNotFound reports that a key does not exist in the data source. The
+// Group caches such misses for a short time, see WithNegativeTTL, and
+// peers pass them on as not-found instead of as failures
+var ErrNotFound = errors.New("geecache: key not found")
+
 // 这么做实际上将一个函数封装成了一个
 // 实现Getter接口的回调函数
 // 之后用户只要是传入 funcGetter 类型的函数，就可以被封装为Getter函数
@@ -31,6 +44,27 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// A GetterWithContext loads data for a key and honors the caller's
+// deadline and cancellation. A Getter passed to NewGroup which also
+// implements GetterWithContext is always called through GetContext
+type GetterWithContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterWithContextFunc 与 GetterFunc 类似，将带context的函数封装为回调函数
+// 它同时实现了 Getter 和 GetterWithContext，可以直接传给 NewGroup
+type GetterWithContextFunc func(context.Context, string) ([]byte, error)
+
+// GetContext implements GetterWithContext
+func (f GetterWithContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter with a background context
+func (f GetterWithContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
 // A Group is a cache namespace and associated data loaded spread over
 // 将缓存抽象为多个group，每个内核都是封装好的cache，支持并发访问
 // 类似于redis的1~50的那种group
@@ -38,13 +72,63 @@ type Group struct {
 	name      string
 	getter    Getter // 当本地缓存和远端节点都加载失败的处理方法，用户提供
 	mainCache cache
+	// hotCache 保存从远端节点取回的热点数据，避免热点key的请求都打到同一个节点上
+	// 只有一部分从远端取回的数据会被放进来，由 hotCacheOneIn 控制
+	hotCache cache
+	// notFound 记录getter或远端节点报告不存在的key，在negativeTTL内直接返回 ErrNotFound
+	notFound    cache
+	negativeTTL time.Duration // 0表示不缓存不存在的key
+
+	bloomOpts *BloomFilterOptions // 为nil时不使用布隆过滤器
+	filter    *bloom.Filter       // 已经存在的key，确定不存在的key不会被加载
 
 	peers PeerPicker // 用于获取远端处理节点
 	// Use singleflight.Group to make sure that
 	// each key is only fetch once
 	singleLoader *singleflight.Group
+
+	ttl             time.Duration // 写入缓存的记录默认的过期时间，0表示永不过期
+	janitorInterval time.Duration // 后台清理过期记录的间隔，0表示只在Get时惰性清理
+	stop            chan struct{} // Close时关闭，通知janitor退出
+	closeOnce       sync.Once
+
+	hotCacheShare float64 // hotCache 占 cacheBytes 的比例，0表示不使用hotCache
+	hotCacheOneIn int     // 从远端取回的数据有 1/hotCacheOneIn 的概率放入hotCache
+
+	backupPeers int // 主节点请求失败后，在本地加载之前还要尝试的备份节点数
+	replicas    int // 本地加载的值一共保存的份数，多出的副本异步写入后续的归属节点
+
+	retry       RetryPolicy            // 请求远端节点失败后的重试策略
+	breakerOpts *CircuitBreakerOptions // 为nil时不使用断路器
+	breakersMu  sync.Mutex
+	breakers    map[PeerGetter]*circuitBreaker // 每个远端节点的断路器
+
+	hedge *hedger // 为nil时不发出对冲请求
+
+	batchWindow  time.Duration // 合并本地加载的时间窗口，0表示不合并
+	batchMaxKeys int           // 一次合并加载的key的上限
+	batcher      *batcher      // getter实现了BatchGetter且开启合并时不为nil
+
+	// 记录过了 refreshAhead*ttl 之后，读取时在后台重新加载，0表示不提前刷新
+	refreshAhead float64
+	// 过期的记录还可以被读到的时长，读到过期的记录时在后台重新加载，0表示不使用
+	maxStale   time.Duration
+	refreshing sync.Map // 正在后台刷新的key
+
+	stats Stats // 统计信息，通过 Stats() 获取快照
 }
 
+const defaultHotCacheOneIn = 10
+
+// notFound 只保存key，不占用 cacheBytes，容量为 cacheBytes 的 1/notFoundCacheDivisor
+const notFoundCacheDivisor = 8
+
+// 向其他归属节点写入一份副本的超时时间
+const replicaPushTimeout = 5 * time.Second
+
+// 后台刷新一个key的超时时间
+const refreshTimeout = 10 * time.Second
+
 var (
 	mu     sync.RWMutex // 负责实现归Groups的并发访问
 	groups = make(map[string]*Group)
@@ -60,33 +144,49 @@ func (g *Group) Register(peers PeerPicker) {
 
 // Get value from group's cache
 func (g *Group) Get(key string) (val ByteView, err error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get but gives up waiting for the load once ctx is done.
+// ctx is passed on to the peer request and to a GetterWithContext
+func (g *Group) GetContext(ctx context.Context, key string) (val ByteView, err error) {
 	var ok bool
 
-	val, ok = g.mainCache.get(key) // 先尝试去本机的group查找
-	if ok {                        // 直接在本机的节点上找到了数据
+	g.stats.Gets.Add(1)
+	val, ok = g.lookupCache(ctx, key) // 先尝试去本机的group查找
+	if ok {                           // 直接在本机的节点上找到了数据
+		g.stats.CacheHits.Add(1)
 		return val, nil
 	}
+	if g.lookupNotFound(key) { // 最近确认过key不存在
+		return ByteView{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	checked, err := g.admit(key)
+	if err != nil {
+		return ByteView{}, err
+	}
 	// 未找到则从回调函数中查找
-	val, err = g.load(key)
+	val, err = g.load(ctx, key)
+	if checked && errors.Is(err, ErrNotFound) { // 通过了本机的布隆过滤器但key不存在
+		g.stats.BloomFalsePositives.Add(1)
+	}
 	return val, err
 }
 
 // 加载未在本机上缓存的数据
 // 留出加载远程节点 or 源数据的接口
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	//将短时间内多个相同key的请求合并
-	viewi, err := g.singleLoader.Do(key, func() (interface{}, error) {
-		if g.peers != nil { // 若有远端节点注册，则去远端节点查看
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
-				}
-				log.Println("[GeeCache] Failed to get from peer", peer, err)
-			}
+	//调用者超时只会让自己放弃等待，其他等待同一个key的调用者不受影响
+	g.stats.Loads.Add(1)
+	viewi, err := g.singleLoader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		g.stats.LoadsDeduped.Add(1)
+		value, err := g.loadOnce(ctx, key)
+		if err != nil {
+			g.populateNotFound(key, err)
+			return nil, err
 		}
-
-		// 若在远端节点查找失败，则转到本地节点处理
-		return g.getLocally(key)
+		return value, nil
 	})
 	// 远端请求或slow DB加载数据结束
 	if err == nil {
@@ -95,11 +195,132 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
+// 依次尝试归属节点和本地的getter加载key，由load保证同一个key同时只有一次加载
+// 归属节点报告key不存在时不再尝试其他节点和本地的getter
+func (g *Group) loadOnce(ctx context.Context, key string) (ByteView, error) {
+	owners := g.pickOwners(ctx, key)
+	// 开启对冲时，主节点响应慢则同时请求下一个归属节点，没有其他归属节点时请求本地的getter
+	if g.hedge != nil && len(owners) > 0 {
+		var hedge PeerGetter
+		if len(owners) > 1 {
+			hedge = owners[1]
+		}
+		value, hedged, err := g.hedgedGet(ctx, key, owners[0], hedge)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			return value, err
+		}
+		// 对冲多取的归属节点只作为对冲请求的目标，失败后只尝试WithBackupPeers的备份节点
+		backups := owners[1:min(len(owners), 1+g.backupPeers)]
+		switch {
+		case !hedged: // 主节点很快就失败了，对冲的节点还没有请求过
+			owners = backups
+		case hedge == nil: // 本地的getter也已经失败了
+			return ByteView{}, err
+		case len(backups) > 0: // 对冲的节点就是第一个备份节点
+			owners = backups[1:]
+		default:
+			owners = nil
+		}
+	}
+
+	// 若有远端节点注册，则去远端节点查看，主节点失败时依次尝试备份节点
+	for _, peer := range owners {
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			g.peerLoaded(key, value)
+			return value, nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			return ByteView{}, err
+		}
+		g.peerFailed(peer, err)
+	}
+
+	// 若在远端节点查找失败，则转到本地节点处理
+	value, err := g.getLocally(ctx, key)
+	if err != nil {
+		g.localFailed(err)
+		return ByteView{}, err
+	}
+	g.localLoaded(key, value)
+	return value, nil
+}
+
+// 记录一次从远端节点成功的加载
+func (g *Group) peerLoaded(key string, value ByteView) {
+	g.stats.PeerLoads.Add(1)
+	g.populateHotCache(key, value)
+}
+
+// 记录一次失败的远端请求
+func (g *Group) peerFailed(peer PeerGetter, err error) {
+	if errors.Is(err, ErrCircuitOpen) { // 断路器断开的节点直接跳过
+		g.stats.PeerCircuitOpen.Add(1)
+		return
+	}
+	g.stats.PeerErrors.Add(1)
+	log.Println("[GeeCache] Failed to get from peer", peer, err)
+}
+
+// 记录一次本地getter成功的加载
+func (g *Group) localLoaded(key string, value ByteView) {
+	g.stats.LocalLoads.Add(1)
+	g.replicate(key, value)
+}
+
+// 记录一次本地getter失败的加载，key不存在不算作错误
+func (g *Group) localFailed(err error) {
+	if !errors.Is(err, ErrNotFound) {
+		g.stats.LocalLoadErrs.Add(1)
+	}
+}
+
+// 返回应该依次尝试的远端归属节点，本机是归属节点或请求是其他节点转发来的时为空
+// 开启对冲时至少返回两个归属节点，第二个作为对冲请求的目标
+func (g *Group) pickOwners(ctx context.Context, key string) []PeerGetter {
+	if g.peers == nil || isForwarded(ctx) {
+		return nil
+	}
+	n := 1 + g.backupPeers
+	if g.hedge != nil && n < 2 {
+		n = 2
+	}
+	if rp, ok := g.peers.(ReplicaPicker); ok && n > 1 {
+		return rp.PickPeers(key, n)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}
+	}
+	return nil
+}
+
+type forwardedKey struct{}
+
+// withForwarded 标记请求是其他节点转发来的。发送方已经选定本机加载key，
+// 本机可能是主节点失败后的备份节点、对冲请求的目标或bounded load下的替代节点，
+// 此时再按本机的视角转发会绕回发送方刚刚跳过的节点，所以未命中时直接在本地加载
+func withForwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, true)
+}
+
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedKey{}).(bool)
+	return forwarded
+}
+
 // 未找到数据时，根据回调函数获取key对应的cache
 // 如果没拿到数据那就返回空
 // 如果拿到了，需要将这个新拿到的kv记录到cache中
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	var bytes []byte
+	var err error
+	if g.batcher != nil { // 与同时在本机加载的其他key合并为一次批量加载
+		bytes, err = g.batcher.get(ctx, key)
+	} else if getter, ok := g.getter.(GetterWithContext); ok {
+		bytes, err = getter.GetContext(ctx, key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil { // 出现错误则返回
 		return ByteView{}, err
 	}
@@ -108,41 +329,419 @@ func (g *Group) getLocally(key string) (ByteView, error) {
 	return value, nil
 }
 
-// 从远端peer中Get缓存
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
-	req := &pb.Request{
+// 将本机加载的值异步写入key的其他归属节点，不阻塞当前的Get
+func (g *Group) replicate(key string, value ByteView) {
+	if g.replicas <= 1 || g.peers == nil {
+		return
+	}
+	rp, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return
+	}
+	req := &pb.SetRequest{
 		Group: g.name,
 		Key:   key,
+		Value: value.ByteSlice(),
 	}
-	res := &pb.Response{}
+	for _, peer := range rp.PickReplicas(key, g.replicas) {
+		go func(peer PeerGetter) {
+			ctx, cancel := context.WithTimeout(context.Background(), replicaPushTimeout)
+			defer cancel()
+			if err := peer.Push(ctx, req); err != nil {
+				g.stats.ReplicaPushErrs.Add(1)
+				log.Println("[GeeCache] Failed to push replica to peer", peer, err)
+				return
+			}
+			g.stats.ReplicaPushes.Add(1)
+		}(peer)
+	}
+}
 
-	err := peer.Get(req, res) // 使用protobuf进行通信
+// 从远端peer中Get缓存
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
+	req := &pb.Request{
+		Group:     g.name,
+		Key:       key,
+		Forwarded: true, // 由peer自己加载，不再转发
+	}
+	res := &pb.Response{}
+	err := g.callPeer(ctx, peer, func(ctx context.Context) error {
+		start := time.Now()
+		err := peer.Get(ctx, req, res) // 使用protobuf进行通信
+		if err == nil && g.hedge != nil {
+			g.hedge.observe(time.Since(start))
+		}
+		return err
+	})
 	if err != nil {
 		return ByteView{}, err
 	}
 	return ByteView{b: res.Value}, nil
 }
 
+// 向peer发出请求，失败时按照重试策略重试，断路器断开时不再发送请求
+func (g *Group) callPeer(ctx context.Context, peer PeerGetter, call func(context.Context) error) error {
+	cb := g.breaker(peer)
+	var err error
+	for attempt := 1; ; attempt++ {
+		if cb != nil && !cb.allow() {
+			if err != nil { // 重试期间断开时返回上一次请求的错误
+				return err
+			}
+			return ErrCircuitOpen
+		}
+		err = call(ctx)
+		// 节点回答了key不存在，与成功一样不需要重试
+		ok := err == nil || errors.Is(err, ErrNotFound)
+		if cb != nil {
+			if !ok && ctx.Err() != nil { // 调用者放弃了请求，不算作节点的失败
+				cb.release()
+			} else {
+				cb.done(ok)
+			}
+		}
+		if ok {
+			return err
+		}
+		if attempt >= g.retry.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if !sleepContext(ctx, g.retry.backoff(attempt)) {
+			return err
+		}
+		g.stats.PeerRetries.Add(1)
+	}
+}
+
+// Set updates the value of key in the cache of the peer which owns it.
+// With a bounded-load placement, the nodes which loaded key while its
+// owner was saturated keep their copy, only Invalidate removes those
+// 由key的归属节点负责保存新值，本机是归属节点时直接写入本地缓存
+func (g *Group) Set(key string, value []byte) error {
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			req := &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: value,
+			}
+			if err := peer.Set(context.Background(), req); err != nil {
+				return err
+			}
+			// 本机上的副本、hotCache中的旧值和不存在的记录不能再被读到
+			g.addedElsewhere(key)
+			return g.updateCopies(key, value, peer)
+		}
+	}
+	g.setLocally(key, value)
+	return g.updateCopies(key, value, nil)
+}
+
+// Remove deletes key from the cache of the peer which owns it and from
+// the other owners which keep a replica of it
+func (g *Group) Remove(key string) error {
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			if err := g.removeFromPeer(peer, key); err != nil {
+				return err
+			}
+			owner = peer
+		}
+	}
+	// 无论归属节点是否为本机，本机上的旧值都需要删掉
+	g.removeLocally(key)
+	return g.updateCopies(key, nil, owner)
+}
+
+// 返回Set和Remove的目标节点。bounded load 模式下PickPeer按负载选择节点，
+// 写入和删除交给它的话环上的归属节点会保留旧值，之后按负载路由到它的读取仍会读到旧值
+func (g *Group) pickOwner(key string) (PeerGetter, bool) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		return rp.PickOwner(key)
+	}
+	return g.peers.PickPeer(key)
+}
+
+// 同步更新其他节点上key的拷贝，owner已经处理过了
+// 其他归属节点的mainCache中保存着副本，value为nil时删除副本，否则推送新值；
+// 开启hotCache时其他节点的hotCache中可能有旧值，与Invalidate一样通知所有节点删除；
+// 开启布隆过滤器时Set还要让所有节点的过滤器记住新key，归属节点变化后才不会误判
+func (g *Group) updateCopies(key string, value []byte, owner PeerGetter) error {
+	if g.peers == nil {
+		return nil
+	}
+	done := map[PeerGetter]bool{owner: true}
+	var errs []error
+	if rp, ok := g.peers.(ReplicaPicker); ok && g.replicas > 1 {
+		for _, peer := range rp.PickReplicas(key, g.replicas) {
+			if done[peer] {
+				continue
+			}
+			done[peer] = true
+			var err error
+			if value == nil {
+				err = g.removeFromPeer(peer, key)
+			} else {
+				err = peer.Push(context.Background(), &pb.SetRequest{Group: g.name, Key: key, Value: value})
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	announce := g.filter != nil && value != nil
+	if g.hotCacheShare > 0 || announce {
+		for _, peer := range g.peers.GetAll() {
+			if done[peer] {
+				continue
+			}
+			var err error
+			if announce { // 对方删除拷贝的同时记住新key
+				err = peer.Set(context.Background(), &pb.SetRequest{Group: g.name, Key: key, KeyOnly: true})
+			} else {
+				err = g.removeFromPeer(peer, key)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Invalidate removes key from the owner and then from every other peer,
+// so that no node keeps serving the stale value
+func (g *Group) Invalidate(key string) error {
+	if err := g.Remove(key); err != nil {
+		return err
+	}
+	if g.peers == nil {
+		return nil
+	}
+
+	owner, _ := g.pickOwner(key)
+	var errs []error
+	for _, peer := range g.peers.GetAll() {
+		if peer == owner { // 归属节点已经在Remove中处理过了
+			continue
+		}
+		if err := g.removeFromPeer(peer, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 由远端节点发来的Set请求直接作用在本机的缓存上，不再转发
+func (g *Group) setLocally(key string, value []byte) {
+	g.populateCache(key, ByteView{b: cloneBytes(value)})
+}
+
+// 其他节点通过Set写入了key：本机的拷贝已经过时，过滤器需要记住这个新key
+func (g *Group) addedElsewhere(key string) {
+	g.removeLocally(key)
+	g.AddToBloomFilter(key)
+}
+
+// 其他归属节点推送来的副本，与setLocally一样只写入本机的缓存
+func (g *Group) pushLocally(key string, value []byte) {
+	g.stats.ReplicasReceived.Add(1)
+	g.setLocally(key, value)
+}
+
+// 由远端节点发来的Remove请求直接作用在本机的缓存上，不再转发
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.notFound.remove(key)
+}
+
+// 通知远端peer删除key
+func (g *Group) removeFromPeer(peer PeerGetter, key string) error {
+	req := &pb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	return peer.Remove(context.Background(), req)
+}
+
 // 将没找到但是心找到的数据添加到cache中
 func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+	g.mainCache.add(key, value, g.ttl)
+	if g.filter != nil {
+		g.filter.Add(key)
+	}
+	if g.negativeTTL > 0 { // key已经存在了
+		g.notFound.remove(key)
+	}
+}
+
+// 加载的结果为key不存在时，在negativeTTL内记住这个结果
+func (g *Group) populateNotFound(key string, err error) {
+	if !errors.Is(err, ErrNotFound) {
+		return
+	}
+	g.stats.NotFound.Add(1)
+	if g.negativeTTL > 0 {
+		g.notFound.add(key, ByteView{}, g.negativeTTL)
+	}
+}
+
+// 最近是否确认过key不存在
+func (g *Group) lookupNotFound(key string) bool {
+	if g.negativeTTL <= 0 {
+		return false
+	}
+	if _, ok := g.notFound.get(key); !ok {
+		return false
+	}
+	g.stats.NegativeHits.Add(1)
+	return true
+}
+
+// 按概率将从远端节点取回的数据放入hotCache
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCacheShare <= 0 {
+		return
+	}
+	if g.hotCacheOneIn > 1 && rand.Intn(g.hotCacheOneIn) != 0 {
+		return
+	}
+	g.hotCache.add(key, value, g.ttl)
+}
+
+// 依次在mainCache和hotCache中查找
+// 转发来的请求只读取未过期的记录：请求方会把结果当作新加载的值再缓存一个TTL，
+// 过期的值经过转发就会在TTL+maxStale之后仍被读到
+func (g *Group) lookupCache(ctx context.Context, key string) (ByteView, bool) {
+	if isForwarded(ctx) {
+		if val, ok := g.mainCache.get(key); ok {
+			return val, true
+		}
+		return g.hotCache.get(key)
+	}
+	if val, ok := g.lookupIn(&g.mainCache, key); ok {
+		return val, true
+	}
+	return g.lookupIn(&g.hotCache, key)
+}
+
+// 在c中查找key，记录已经过期（但在maxStale之内）或快要过期时照常返回，并在后台刷新
+func (g *Group) lookupIn(c *cache, key string) (ByteView, bool) {
+	if g.maxStale <= 0 && g.refreshAhead <= 0 {
+		return c.get(key)
+	}
+	item, ok := c.getItem(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	value := item.Value.(ByteView)
+	if item.Expire.IsZero() { // 永不过期的记录不需要刷新
+		return value, true
+	}
+	now := time.Now()
+	switch {
+	case !now.Before(item.Expire):
+		g.stats.StaleHits.Add(1)
+		g.refresh(c, key)
+	case g.refreshAhead > 0 && now.Sub(item.Added) >= time.Duration(g.refreshAhead*float64(item.Expire.Sub(item.Added))):
+		g.refresh(c, key)
+	}
+	return value, true
+}
+
+// 在后台重新加载key并写回c，同一个key同时只有一次刷新
+// 加载经过singleflight，与同时发生的未命中共用一次加载
+func (g *Group) refresh(c *cache, key string) {
+	if _, running := g.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	g.stats.Refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		value, err := g.load(ctx, key)
+		if errors.Is(err, ErrNotFound) { // key已经被删除，不能再读到旧值
+			c.remove(key)
+			return
+		}
+		if err != nil { // 继续使用旧值，直到超过maxStale
+			g.stats.RefreshErrs.Add(1)
+			log.Println("[GeeCache] Failed to refresh", key, err)
+			return
+		}
+		c.add(key, value, g.ttl)
+	}()
+}
+
+// 定期清理mainCache、hotCache和notFound中已经过期的记录，直到Close
+func (g *Group) janitor() {
+	ticker := time.NewTicker(g.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.mainCache.removeExpired()
+			g.hotCache.removeExpired()
+			g.notFound.removeExpired()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background goroutine started by WithJanitor, expired
+// entries are then only dropped lazily. The Group can still be used.
+// NewGroup closes the Group it replaces, Close may be called many times
+func (g *Group) Close() {
+	g.closeOnce.Do(func() { close(g.stop) })
 }
 
 // NewGroup create a new instance of Group
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
-	mu.Lock()
-	defer mu.Unlock()
 
 	g := &Group{
-		name:         name,
-		getter:       getter,
-		mainCache:    cache{cacheBytes: cacheBytes},
-		singleLoader: new(singleflight.Group),
+		name:          name,
+		getter:        getter,
+		mainCache:     cache{cacheBytes: cacheBytes},
+		notFound:      cache{cacheBytes: cacheBytes / notFoundCacheDivisor},
+		singleLoader:  new(singleflight.Group),
+		hotCacheOneIn: defaultHotCacheOneIn,
+		batchWindow:   defaultBatchWindow,
+		batchMaxKeys:  defaultBatchMaxKeys,
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
+	if g.bloomOpts != nil { // 填充过滤器可能很慢，不持有全局锁
+		g.initFilter(*g.bloomOpts)
+	}
+	if bg, ok := getter.(BatchGetter); ok && g.batchWindow > 0 {
+		g.batcher = newBatcher(g, bg)
+	}
+	g.mainCache.stale = g.maxStale
+	g.hotCache.stale = g.maxStale
+	if g.hotCacheShare > 0 { // hotCache 从 cacheBytes 中分出一部分
+		hotBytes := int64(float64(cacheBytes) * g.hotCacheShare)
+		g.hotCache.cacheBytes = hotBytes
+		g.mainCache.cacheBytes = cacheBytes - hotBytes
+	}
+	if g.janitorInterval > 0 {
+		go g.janitor()
+	}
+	mu.Lock()
+	old := groups[name]
 	groups[name] = g
+	mu.Unlock()
+	if old != nil { // 被替换的group不再能通过名称找到，停止它的janitor
+		old.Close()
+	}
 
 	return g
 }
@@ -154,3 +753,15 @@ func GetGroup(name string) *Group {
 	mu.RUnlock()
 	return g
 }
+
+// 按名称排序返回所有的group
+func allGroups() []*Group {
+	mu.RLock()
+	gs := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g)
+	}
+	mu.RUnlock()
+	sort.Slice(gs, func(i, j int) bool { return gs[i].name < gs[j].name })
+	return gs
+}
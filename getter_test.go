@@ -1,9 +1,18 @@
-package main
+package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -54,3 +63,1012 @@ func TestGet(t *testing.T) {
 	}
 
 }
+
+func TestSetRemove(t *testing.T) {
+	loads := 0
+	gee := NewGroup("set-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}))
+
+	if err := gee.Set("Tom", []byte("999")); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "999" || loads != 0 {
+		t.Fatalf("expected Tom=999 from cache, got %q (err %v, loads %d)", v.String(), err, loads)
+	}
+
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatalf("remove Tom failed: %v", err)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" || loads != 1 {
+		t.Fatalf("expected Tom reloaded from getter, got %q (err %v, loads %d)", v.String(), err, loads)
+	}
+
+	if err := gee.Invalidate("Tom"); err != nil {
+		t.Fatalf("invalidate Tom failed: %v", err)
+	}
+	if _, err := gee.Get("Tom"); err != nil || loads != 2 {
+		t.Fatalf("expected Tom reloaded after invalidate, loads %d", loads)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	loads := 0
+	gee := NewGroup("ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithTTL(50*time.Millisecond), WithJanitor(10*time.Millisecond))
+
+	gee.Get("Sam")
+	gee.Get("Sam")
+	if loads != 1 {
+		t.Fatalf("Sam should be cached before expiring, loads %d", loads)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := gee.mainCache.removeExpired(); n != 0 {
+		t.Fatalf("janitor left %d expired entries", n)
+	}
+	gee.Get("Sam")
+	if loads != 2 {
+		t.Fatalf("Sam should be reloaded after expiring, loads %d", loads)
+	}
+
+	// Close之后janitor不再清理，过期的记录只在Get时删除
+	gee.Close()
+	gee.Close()
+	gee.Get("Tom")
+	time.Sleep(100 * time.Millisecond)
+	if n := gee.mainCache.removeExpired(); n != 2 {
+		t.Fatalf("expected 2 expired entries left after close, got %d", n)
+	}
+
+	// 同名的新group替换旧group时停止旧group的janitor
+	old := NewGroup("ttl-replaced", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithJanitor(time.Hour))
+	NewGroup("ttl-replaced", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	select {
+	case <-old.stop:
+	default:
+		t.Fatalf("replaced group should be closed")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	release := make(chan struct{})
+	gee := NewGroup("context", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-release:
+			return []byte(key), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+
+	// 没有截止时间的调用者等待同一个key的加载
+	done := make(chan ByteView, 1)
+	go func() {
+		v, _ := gee.Get("Ywh")
+		done <- v
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "Ywh"); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	if v := <-done; v.String() != "Ywh" {
+		t.Fatalf("the shared load should not be cancelled, got %q", v.String())
+	}
+}
+
+// fakePeer 模拟远端节点，记录被请求的次数
+type fakePeer struct {
+	mu    sync.Mutex
+	gets  int
+	store map[string][]byte
+	err   error // 不为nil时Get返回该错误，模拟故障的节点
+	fails int   // 前fails次Get返回错误，模拟短暂的故障
+
+	forwarded int      // 带有Forwarded标记的Get请求数
+	announced []string // KeyOnly的Set请求中的key
+
+	delay     time.Duration // Get返回之前等待的时间，ctx结束时提前返回
+	cancelled int           // 等待期间被取消的Get请求数
+
+	pushed  map[string][]byte // 推送到该节点的副本
+	batches [][]string        // 每次GetMany请求的key
+}
+
+func newFakePeer() *fakePeer {
+	return &fakePeer{store: make(map[string][]byte), pushed: make(map[string][]byte)}
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if p.delay > 0 {
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.cancelled++
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets++
+	if in.GetForwarded() {
+		p.forwarded++
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if p.gets <= p.fails {
+		return fmt.Errorf("transient failure %d", p.gets)
+	}
+	out.Value = []byte("peer-" + in.GetKey())
+	return nil
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if in.GetKeyOnly() {
+		p.announced = append(p.announced, in.GetKey())
+		delete(p.store, in.GetKey())
+		return nil
+	}
+	p.store[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+func (p *fakePeer) Remove(ctx context.Context, in *pb.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.store, in.GetKey())
+	delete(p.pushed, in.GetKey())
+	return nil
+}
+
+func (p *fakePeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, in.GetKeys())
+	if p.err != nil {
+		return p.err
+	}
+	out.Values = make(map[string][]byte)
+	for _, key := range in.GetKeys() {
+		out.Values[key] = []byte("peer-" + key)
+	}
+	return nil
+}
+
+func (p *fakePeer) Push(ctx context.Context, in *pb.SetRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pushed[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+// 副本是异步推送的，等待key出现在该节点上
+func (p *fakePeer) waitPushed(key string) ([]byte, bool) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		v, ok := p.pushed[key]
+		p.mu.Unlock()
+		if ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// fakePicker 将所有key都交给同一个远端节点
+type fakePicker struct {
+	peer PeerGetter
+}
+
+func (p fakePicker) PickPeer(key string) (PeerGetter, bool) { return p.peer, true }
+
+func (p fakePicker) GetAll() []PeerGetter { return []PeerGetter{p.peer} }
+
+// fakeRoutePicker 按key的第一个字符选择归属节点，没有对应节点的key由本机负责
+type fakeRoutePicker map[byte]PeerGetter
+
+func (p fakeRoutePicker) PickPeer(key string) (PeerGetter, bool) {
+	peer, ok := p[key[0]]
+	return peer, ok
+}
+
+func (p fakeRoutePicker) GetAll() []PeerGetter {
+	var peers []PeerGetter
+	for _, peer := range p {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// fakeReplicaPicker 按顺序返回固定的归属节点
+// owners 为空时本机是主节点，replicas 为本机之外的其他归属节点
+type fakeReplicaPicker struct {
+	owners   []PeerGetter
+	replicas []PeerGetter
+}
+
+func (p fakeReplicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	if len(p.owners) == 0 {
+		return nil, false
+	}
+	return p.owners[0], true
+}
+
+func (p fakeReplicaPicker) PickOwner(key string) (PeerGetter, bool) { return p.PickPeer(key) }
+
+func (p fakeReplicaPicker) GetAll() []PeerGetter { return append(p.owners, p.replicas...) }
+
+func (p fakeReplicaPicker) PickReplicas(key string, n int) []PeerGetter {
+	if n-1 < len(p.replicas) {
+		return p.replicas[:n-1]
+	}
+	return p.replicas
+}
+
+func (p fakeReplicaPicker) PickPeers(key string, n int) []PeerGetter {
+	if n > len(p.owners) {
+		n = len(p.owners)
+	}
+	return p.owners[:n]
+}
+
+func TestHotCache(t *testing.T) {
+	peer := newFakePeer()
+	gee := NewGroup("hot", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("key %s is owned by peer", key)
+	}), WithHotCache(0.25), WithHotCacheOneIn(1))
+	gee.Register(fakePicker{peer})
+
+	if gee.hotCache.cacheBytes != 2<<10/4 || gee.mainCache.cacheBytes != 2<<10*3/4 {
+		t.Fatalf("unexpected split main %d hot %d", gee.mainCache.cacheBytes, gee.hotCache.cacheBytes)
+	}
+
+	for i := 0; i < 3; i++ {
+		if v, err := gee.Get("Jack"); err != nil || v.String() != "peer-Jack" {
+			t.Fatalf("get Jack from peer failed: %q %v", v.String(), err)
+		}
+	}
+	if peer.gets != 1 {
+		t.Fatalf("hot key should be fetched from peer once, got %d", peer.gets)
+	}
+	if _, ok := gee.mainCache.get("Jack"); ok {
+		t.Fatalf("remote key should not be stored in mainCache")
+	}
+
+	if err := gee.Invalidate("Jack"); err != nil {
+		t.Fatalf("invalidate Jack failed: %v", err)
+	}
+	gee.Get("Jack")
+	if peer.gets != 2 {
+		t.Fatalf("invalidate should drop the hot copy, peer gets %d", peer.gets)
+	}
+
+	// Set和Remove也要删除其他节点hotCache中的旧值
+	owner, other := newFakePeer(), newFakePeer()
+	gee = NewGroup("hot-set", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithHotCache(0.25))
+	gee.Register(fakeRoutePicker{'T': owner, 'X': other})
+	other.store["Tom"] = []byte("old")
+	if err := gee.Set("Tom", []byte("630")); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+	if _, ok := other.store["Tom"]; ok || string(owner.store["Tom"]) != "630" {
+		t.Fatalf("set should reach the owner and drop the other copies, owner %q", owner.store["Tom"])
+	}
+	other.store["Tom"] = []byte("630")
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatalf("remove Tom failed: %v", err)
+	}
+	if _, ok := other.store["Tom"]; ok {
+		t.Fatalf("remove should drop the other copies")
+	}
+
+	// 未开启hotCache时其他节点上不会有拷贝，不需要通知
+	gee = NewGroup("no-hot-set", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	gee.Register(fakeRoutePicker{'T': owner, 'X': other})
+	other.store["Tom"] = []byte("630")
+	gee.Set("Tom", []byte("631"))
+	if _, ok := other.store["Tom"]; !ok {
+		t.Fatalf("set without hot cache should only reach the owner")
+	}
+}
+
+func TestStats(t *testing.T) {
+	gee := NewGroup("stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("not found key:%s", key)
+	}))
+
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("unknown")
+
+	stats := gee.Stats()
+	if stats.Gets.Get() != 3 || stats.CacheHits.Get() != 1 || stats.Loads.Get() != 2 ||
+		stats.LocalLoads.Get() != 1 || stats.LocalLoadErrs.Get() != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	cs := gee.CacheStats(MainCache)
+	if cs.Items != 1 || cs.Bytes != int64(len("Tom")+len(db["Tom"])) || cs.Gets != 3 || cs.Hits != 1 {
+		t.Fatalf("unexpected main cache stats %+v", cs)
+	}
+	if cs := gee.CacheStats(HotCache); cs.Items != 0 {
+		t.Fatalf("unexpected hot cache stats %+v", cs)
+	}
+}
+
+func TestBackupPeers(t *testing.T) {
+	primary, backup := newFakePeer(), newFakePeer()
+	primary.err = fmt.Errorf("primary is down")
+
+	localLoads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localLoads++
+		return []byte("db-" + key), nil
+	})
+
+	gee := NewGroup("backup", 2<<10, getter, WithBackupPeers(1))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{primary, backup}})
+	if v, err := gee.Get("Mike"); err != nil || v.String() != "peer-Mike" {
+		t.Fatalf("expected Mike from the backup owner, got %q (err %v)", v.String(), err)
+	}
+	if primary.gets != 1 || backup.gets != 1 || localLoads != 0 {
+		t.Fatalf("unexpected calls primary %d backup %d local %d", primary.gets, backup.gets, localLoads)
+	}
+
+	// 未开启时主节点失败后直接在本地加载
+	gee = NewGroup("no-backup", 2<<10, getter)
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{primary, backup}})
+	if v, err := gee.Get("Mike"); err != nil || v.String() != "db-Mike" {
+		t.Fatalf("expected Mike loaded locally, got %q (err %v)", v.String(), err)
+	}
+	if backup.gets != 1 || localLoads != 1 {
+		t.Fatalf("backup should not be tried, backup %d local %d", backup.gets, localLoads)
+	}
+	if primary.forwarded != primary.gets || backup.forwarded != backup.gets {
+		t.Fatalf("requests to the owners should be marked as forwarded")
+	}
+
+	// 备份节点收到转发来的请求时直接在本地加载，不会再请求已经失败的主节点
+	gee = NewGroup("backup-forwarded", 2<<10, getter, WithBackupPeers(1))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{primary}})
+	if v, err := gee.GetContext(withForwarded(context.Background()), "Mike"); err != nil || v.String() != "db-Mike" {
+		t.Fatalf("expected Mike loaded locally, got %q (err %v)", v.String(), err)
+	}
+	if primary.gets != 2 || localLoads != 2 {
+		t.Fatalf("forwarded request should not go back to the primary, primary %d local %d", primary.gets, localLoads)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	replica1, replica2, replica3 := newFakePeer(), newFakePeer(), newFakePeer()
+	loads := 0
+	gee := NewGroup("replication", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}), WithReplication(3))
+	gee.Register(fakeReplicaPicker{replicas: []PeerGetter{replica1, replica2, replica3}})
+
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" || loads != 1 {
+		t.Fatalf("expected Tom loaded locally, got %q (loads %d, err %v)", v.String(), loads, err)
+	}
+	for i, peer := range []*fakePeer{replica1, replica2} {
+		if v, ok := peer.waitPushed("Tom"); !ok || string(v) != "db-Tom" {
+			t.Fatalf("replica %d: expected Tom=db-Tom pushed, got %q", i+1, v)
+		}
+	}
+	if _, ok := replica3.waitPushed("Tom"); ok {
+		t.Fatalf("only the next 2 owners should get a replica")
+	}
+	if s := gee.Stats(); s.ReplicaPushes.Get() != 2 || s.ReplicaPushErrs.Get() != 0 {
+		t.Fatalf("unexpected replica stats pushes %d errors %d", s.ReplicaPushes.Get(), s.ReplicaPushErrs.Get())
+	}
+
+	// 从缓存中读到的值不会再次推送
+	gee.Get("Tom")
+	if s := gee.Stats(); s.ReplicaPushes.Get() != 2 {
+		t.Fatalf("cache hits should not be replicated, pushes %d", s.ReplicaPushes.Get())
+	}
+}
+
+func TestRetry(t *testing.T) {
+	peer := newFakePeer()
+	peer.fails = 2
+	localLoads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localLoads++
+		return []byte("db-" + key), nil
+	})
+
+	gee := NewGroup("retry", 2<<10, getter, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	gee.Register(fakePicker{peer})
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "peer-Tom" {
+		t.Fatalf("expected Tom from the peer after retries, got %q (err %v)", v.String(), err)
+	}
+	if s := gee.Stats(); peer.gets != 3 || s.PeerRetries.Get() != 2 || s.PeerErrors.Get() != 0 || localLoads != 0 {
+		t.Fatalf("unexpected gets %d retries %d errors %d local %d", peer.gets, s.PeerRetries.Get(), s.PeerErrors.Get(), localLoads)
+	}
+
+	// 重试次数用完后在本地加载
+	peer.err = fmt.Errorf("peer is down")
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "db-Jack" {
+		t.Fatalf("expected Jack loaded locally, got %q (err %v)", v.String(), err)
+	}
+	if s := gee.Stats(); peer.gets != 6 || s.PeerErrors.Get() != 1 || localLoads != 1 {
+		t.Fatalf("unexpected gets %d errors %d local %d", peer.gets, s.PeerErrors.Get(), localLoads)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	peer := newFakePeer()
+	peer.err = fmt.Errorf("peer is down")
+	gee := NewGroup("breaker", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 2, OpenTimeout: time.Hour}))
+	gee.Register(fakePicker{peer})
+
+	for _, key := range []string{"Tom", "Jack", "Sam", "Ywh"} {
+		if v, err := gee.Get(key); err != nil || v.String() != "db-"+key {
+			t.Fatalf("expected %s loaded locally, got %q (err %v)", key, v.String(), err)
+		}
+	}
+	// 连续失败两次后断路器断开，之后的请求不再发往该节点
+	if s := gee.Stats(); peer.gets != 2 || s.PeerErrors.Get() != 2 || s.PeerCircuitOpen.Get() != 2 {
+		t.Fatalf("unexpected gets %d errors %d skipped %d", peer.gets, s.PeerErrors.Get(), s.PeerCircuitOpen.Get())
+	}
+}
+
+func TestHedging(t *testing.T) {
+	slow, replica := newFakePeer(), newFakePeer()
+	slow.delay = time.Minute
+	localLoads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localLoads++
+		return []byte("db-" + key), nil
+	})
+
+	// 主节点太慢时向下一个归属节点发出对冲请求
+	gee := NewGroup("hedge-replica", 2<<10, getter, WithHedging(HedgeOptions{MaxDelay: 5 * time.Millisecond}))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{slow, replica}})
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "peer-Tom" {
+		t.Fatalf("expected Tom from the replica owner, got %q (err %v)", v.String(), err)
+	}
+	if s := gee.Stats(); s.HedgedRequests.Get() != 1 || s.HedgeWins.Get() != 1 || s.PeerLoads.Get() != 1 || localLoads != 0 {
+		t.Fatalf("unexpected hedged %d wins %d peer loads %d local %d",
+			s.HedgedRequests.Get(), s.HedgeWins.Get(), s.PeerLoads.Get(), localLoads)
+	}
+
+	// 没有其他归属节点时由本地的getter对冲
+	gee = NewGroup("hedge-local", 2<<10, getter, WithHedging(HedgeOptions{MaxDelay: 5 * time.Millisecond}))
+	gee.Register(fakePicker{slow})
+	if v, err := gee.Get("Jack"); err != nil || v.String() != "db-Jack" {
+		t.Fatalf("expected Jack from the local getter, got %q (err %v)", v.String(), err)
+	}
+	if s := gee.Stats(); s.HedgeWins.Get() != 1 || s.LocalLoads.Get() != 1 || localLoads != 1 {
+		t.Fatalf("unexpected wins %d local loads %d", s.HedgeWins.Get(), s.LocalLoads.Get())
+	}
+
+	// 输掉的请求会被取消
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		slow.mu.Lock()
+		cancelled := slow.cancelled
+		slow.mu.Unlock()
+		if cancelled == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the slow requests should be cancelled, got %d", cancelled)
+		}
+	}
+
+	// 主节点在对冲延迟内返回时不发出对冲请求
+	fast := newFakePeer()
+	gee = NewGroup("hedge-fast", 2<<10, getter, WithHedging(HedgeOptions{MaxDelay: time.Second}))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{fast, replica}})
+	gee.Get("Sam")
+	if s := gee.Stats(); s.HedgedRequests.Get() != 0 || fast.gets != 1 || replica.gets != 1 {
+		t.Fatalf("a fast owner should not be hedged, hedged %d", s.HedgedRequests.Get())
+	}
+
+	// 主节点在对冲之前就失败时，没有开启WithBackupPeers则不会尝试对冲的目标节点
+	down, second := newFakePeer(), newFakePeer()
+	down.err = fmt.Errorf("primary is down")
+	gee = NewGroup("hedge-no-backup", 2<<10, getter, WithHedging(HedgeOptions{MaxDelay: time.Second}))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{down, second}})
+	if v, err := gee.Get("Mike"); err != nil || v.String() != "db-Mike" {
+		t.Fatalf("expected Mike loaded locally, got %q (err %v)", v.String(), err)
+	}
+	if second.gets != 0 || localLoads != 2 {
+		t.Fatalf("hedging alone should not retry on the next owner, second %d local %d", second.gets, localLoads)
+	}
+	gee = NewGroup("hedge-backup", 2<<10, getter, WithHedging(HedgeOptions{MaxDelay: time.Second}), WithBackupPeers(1))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{down, second}})
+	if v, err := gee.Get("Mike"); err != nil || v.String() != "peer-Mike" || second.gets != 1 {
+		t.Fatalf("expected Mike from the backup owner, got %q (err %v)", v.String(), err)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	h := newHedger(HedgeOptions{Percentile: 0.9, MinDelay: 5 * time.Millisecond, MaxDelay: 80 * time.Millisecond})
+	if d := h.delay(); d != 80*time.Millisecond {
+		t.Fatalf("expected MaxDelay without samples, got %v", d)
+	}
+	for i := 1; i <= 50; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.delay(); d != 45*time.Millisecond {
+		t.Fatalf("expected the 90th percentile 45ms, got %v", d)
+	}
+	for i := 0; i < hedgeWindowSize; i++ {
+		h.observe(time.Millisecond)
+	}
+	if d := h.delay(); d != 5*time.Millisecond {
+		t.Fatalf("expected the delay clamped to MinDelay, got %v", d)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	peerA, peerB := newFakePeer(), newFakePeer()
+	var mu sync.Mutex
+	var localKeys []string
+	gee := NewGroup("get-many", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		localKeys = append(localKeys, key)
+		mu.Unlock()
+		if key == "x-missing" {
+			return nil, fmt.Errorf("key %s not exist", key)
+		}
+		return []byte("db-" + key), nil
+	}))
+	gee.Register(fakeRoutePicker{'a': peerA, 'b': peerB})
+	gee.Get("x-cached")
+
+	values, err := gee.GetMany([]string{"a1", "b1", "x1", "a2", "x-cached", "a1", "x-missing"})
+	if err == nil || !strings.Contains(err.Error(), "x-missing") {
+		t.Fatalf("expected the error of x-missing, got %v", err)
+	}
+	want := map[string]string{"a1": "peer-a1", "a2": "peer-a2", "b1": "peer-b1", "x1": "db-x1", "x-cached": "db-x-cached"}
+	if len(values) != len(want) {
+		t.Fatalf("expected %d values, got %d", len(want), len(values))
+	}
+	for key, v := range want {
+		if values[key].String() != v {
+			t.Fatalf("expected %s=%s, got %q", key, v, values[key].String())
+		}
+	}
+	// 每个远端节点只收到一个批量请求
+	if !reflect.DeepEqual(peerA.batches, [][]string{{"a1", "a2"}}) || !reflect.DeepEqual(peerB.batches, [][]string{{"b1"}}) {
+		t.Fatalf("unexpected batches a %v b %v", peerA.batches, peerB.batches)
+	}
+	sort.Strings(localKeys)
+	if !reflect.DeepEqual(localKeys, []string{"x-cached", "x-missing", "x1"}) {
+		t.Fatalf("unexpected local loads %v", localKeys)
+	}
+	if s := gee.Stats(); s.Gets.Get() != 7 || s.CacheHits.Get() != 1 || s.PeerLoads.Get() != 3 || s.LocalLoads.Get() != 2 {
+		t.Fatalf("unexpected stats gets %d hits %d peer loads %d local loads %d",
+			s.Gets.Get(), s.CacheHits.Get(), s.PeerLoads.Get(), s.LocalLoads.Get())
+	}
+
+	// 远端节点失败时在本地加载
+	peerA.err = fmt.Errorf("peer is down")
+	values, err = gee.GetMany([]string{"a3"})
+	if err != nil || values["a3"].String() != "db-a3" {
+		t.Fatalf("expected a3 loaded locally, got %q (err %v)", values["a3"].String(), err)
+	}
+}
+
+// batchGetter 记录每次批量加载的key
+type batchGetter struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (g *batchGetter) Get(key string) ([]byte, error) {
+	return nil, fmt.Errorf("single loads are not expected")
+}
+
+func (g *batchGetter) GetMany(keys []string) (map[string][]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, keys)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if key != "missing" {
+			values[key] = []byte("db-" + key)
+		}
+	}
+	return values, nil
+}
+
+func TestGetManyBatchGetter(t *testing.T) {
+	getter := &batchGetter{}
+	gee := NewGroup("get-many-batch", 2<<10, getter)
+
+	values, err := gee.GetMany([]string{"Tom", "Jack", "missing"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for the missing key, got %v", err)
+	}
+	if len(values) != 2 || values["Tom"].String() != "db-Tom" || values["Jack"].String() != "db-Jack" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if len(getter.calls) != 1 || len(getter.calls[0]) != 3 {
+		t.Fatalf("expected one batch load, got %v", getter.calls)
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" || len(getter.calls) != 1 {
+		t.Fatalf("batch loaded values should be cached, got %q (err %v)", v.String(), err)
+	}
+}
+
+func TestBatchWindow(t *testing.T) {
+	getter := &batchGetter{}
+	gee := NewGroup("batch-window", 2<<10, getter, WithBatchWindow(20*time.Millisecond, 0))
+
+	// 同时在本机加载的key合并为一次GetMany
+	keys := []string{"Tom", "Jack", "Sam", "Ywh", "missing"}
+	var wg sync.WaitGroup
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			v, err := gee.Get(key)
+			if err == nil && v.String() != "db-"+key {
+				err = fmt.Errorf("unexpected value %q", v.String())
+			}
+			errs[i] = err
+		}(i, key)
+	}
+	wg.Wait()
+	for i, err := range errs[:4] {
+		if err != nil {
+			t.Fatalf("get %s failed: %v", keys[i], err)
+		}
+	}
+	if !errors.Is(errs[4], ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for the missing key, got %v", errs[4])
+	}
+	if len(getter.calls) != 1 || len(getter.calls[0]) != len(keys) {
+		t.Fatalf("expected one batch of %d keys, got %v", len(keys), getter.calls)
+	}
+	if n := gee.Stats().BatchLoads; n.Get() != 1 {
+		t.Fatalf("expected 1 batch load, got %d", n.Get())
+	}
+
+	// 达到maxKeys时不等时间窗口结束
+	getter = &batchGetter{}
+	gee = NewGroup("batch-max-keys", 2<<10, getter, WithBatchWindow(time.Hour, 1))
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("expected Tom loaded in a batch of 1, got %q (err %v)", v.String(), err)
+	}
+
+	// window <= 0 时不合并，直接调用Get
+	gee = NewGroup("batch-off", 2<<10, getter, WithBatchWindow(0, 0))
+	if _, err := gee.Get("Tom"); err == nil {
+		t.Fatalf("expected the single Get of batchGetter to be called")
+	}
+
+	// 批量加载没有返回的key同样记入不存在的缓存
+	getter = &batchGetter{}
+	gee = NewGroup("batch-not-found", 2<<10, getter, WithNegativeTTL(time.Minute))
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for missing, got %v", err)
+		}
+	}
+	if _, err := gee.GetMany([]string{"missing"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing, got %v", err)
+	}
+	if len(getter.calls) != 1 {
+		t.Fatalf("missing should be loaded once, got %v", getter.calls)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	})
+	gee := NewGroup("negative", 2<<10, getter, WithNegativeTTL(50*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("Tom"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	s := gee.Stats()
+	if loads != 1 || s.NegativeHits.Get() != 2 || s.NotFound.Get() != 1 || s.LocalLoadErrs.Get() != 0 {
+		t.Fatalf("expected one load and 2 negative hits, got loads %d hits %d", loads, s.NegativeHits.Get())
+	}
+
+	// Set之后key存在了，不存在的记录不能再被读到
+	gee.Set("Tom", []byte("630"))
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expected Tom=630 after set, got %q (err %v)", v.String(), err)
+	}
+
+	// 过期之后重新加载
+	gee.Get("Jack")
+	time.Sleep(60 * time.Millisecond)
+	gee.Get("Jack")
+	if loads != 3 {
+		t.Fatalf("expected Jack loaded again after the negative TTL, loads %d", loads)
+	}
+
+	// 未开启时每次都重新加载
+	gee = NewGroup("no-negative", 2<<10, getter)
+	gee.Get("Sam")
+	gee.Get("Sam")
+	if loads != 5 {
+		t.Fatalf("expected every get to load without negative caching, loads %d", loads)
+	}
+}
+
+func TestPeerNotFound(t *testing.T) {
+	primary, backup := newFakePeer(), newFakePeer()
+	primary.err = fmt.Errorf("%w: Mike", ErrNotFound)
+
+	localLoads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		localLoads++
+		return []byte("db-" + key), nil
+	})
+	gee := NewGroup("peer-not-found", 2<<10, getter,
+		WithBackupPeers(1), WithRetry(RetryPolicy{MaxAttempts: 3}),
+		WithCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1}),
+		WithNegativeTTL(time.Minute))
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{primary, backup}})
+
+	// 归属节点确认key不存在时不重试，也不再尝试备份节点和本地的getter
+	for i := 0; i < 2; i++ {
+		if _, err := gee.Get("Mike"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound from the owner, got %v", err)
+		}
+	}
+	s := gee.Stats()
+	if primary.gets != 1 || backup.gets != 0 || localLoads != 0 || s.PeerErrors.Get() != 0 {
+		t.Fatalf("unexpected calls primary %d backup %d local %d errors %d",
+			primary.gets, backup.gets, localLoads, s.PeerErrors.Get())
+	}
+	if b := gee.breaker(primary); !b.allow() {
+		t.Fatalf("not-found must not open the circuit breaker")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if key == "Ghost" {
+			return nil, ErrNotFound
+		}
+		return []byte("db-" + key), nil
+	})
+	gee := NewGroup("bloom", 2<<10, getter, WithBloomFilter(BloomFilterOptions{
+		ExpectedKeys: 100,
+		Seed: func(add func(string)) error {
+			for _, key := range []string{"Tom", "Jack", "Ghost"} {
+				add(key)
+			}
+			return nil
+		},
+	}))
+	peer, gone := newFakePeer(), newFakePeer()
+	gone.err = fmt.Errorf("%w: gone", ErrNotFound)
+	routes := fakeRoutePicker{'R': peer, 'Z': gone}
+	gee.Register(routes)
+
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "db-Tom" {
+		t.Fatalf("expected seeded Tom loaded, got %q (err %v)", v.String(), err)
+	}
+	// 本机负责的key，确定不存在时不调用getter
+	for _, key := range []string{"Nobody", "Nope", "nobody"} {
+		if _, err := gee.Get(key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %s rejected with ErrNotFound, got %v", key, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("rejected keys must not be loaded, loads %d", loads)
+	}
+	// 其他节点负责的key由归属节点判断，本机的过滤器可能没见过通过其他节点写入的key
+	if v, err := gee.Get("Random"); err != nil || v.String() != "peer-Random" || peer.gets != 1 {
+		t.Fatalf("expected Random from its owner, got %q (err %v, peer gets %d)", v.String(), err, peer.gets)
+	}
+
+	// Set和AddToBloomFilter之后的key可以通过
+	gee.Set("Sam", []byte("567"))
+	if v, err := gee.Get("Sam"); err != nil || v.String() != "567" {
+		t.Fatalf("expected Sam=567 after set, got %q (err %v)", v.String(), err)
+	}
+	gee.AddToBloomFilter("Kate")
+	if v, err := gee.Get("Kate"); err != nil || v.String() != "db-Kate" {
+		t.Fatalf("expected Kate loaded after AddToBloomFilter, got %q (err %v)", v.String(), err)
+	}
+	if _, err := gee.Get("Ghost"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for Ghost, got %v", err)
+	}
+	// 归属节点报告不存在的key没有经过本机的过滤器，不算作误判
+	if _, err := gee.Get("Zed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for Zed from its owner, got %v", err)
+	}
+	s := gee.Stats()
+	if !reflect.DeepEqual(peer.announced, []string{"Sam"}) || !reflect.DeepEqual(gone.announced, []string{"Sam"}) {
+		t.Fatalf("set should tell every peer about Sam, got %v and %v", peer.announced, gone.announced)
+	}
+	if s.BloomRejects.Get() != 3 || s.BloomFalsePositives.Get() != 1 {
+		t.Fatalf("expected 3 rejects and 1 false positive, got %d and %d",
+			s.BloomRejects.Get(), s.BloomFalsePositives.Get())
+	}
+
+	// 写入其他节点的key也要记住，节点变化后本机成为归属节点时不会拒绝它
+	if err := gee.Set("Rex", []byte("dog")); err != nil || string(peer.store["Rex"]) != "dog" {
+		t.Fatalf("expected Rex set on its owner, got %q (err %v)", peer.store["Rex"], err)
+	}
+	delete(routes, 'R')
+	if v, err := gee.Get("Rex"); err != nil || v.String() != "db-Rex" {
+		t.Fatalf("expected Rex loaded after its owner left, got %q (err %v)", v.String(), err)
+	}
+
+	// 没有Seed的过滤器是空的，会拒绝所有的key
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected WithBloomFilter without Seed to panic")
+			}
+		}()
+		WithBloomFilter(BloomFilterOptions{})
+	}()
+
+	// 填充失败时不使用过滤器，避免拒绝存在的key
+	gee = NewGroup("bloom-seed-failed", 2<<10, getter, WithBloomFilter(BloomFilterOptions{
+		Seed: func(add func(string)) error { return fmt.Errorf("db is down") },
+	}))
+	if v, err := gee.Get("Mike"); err != nil || v.String() != "db-Mike" {
+		t.Fatalf("expected Mike loaded without a filter, got %q (err %v)", v.String(), err)
+	}
+}
+
+// 每次加载返回新的版本号
+type versionGetter struct {
+	loads atomic.Int64
+}
+
+func (g *versionGetter) Get(key string) ([]byte, error) {
+	return []byte(key + "-v" + strconv.FormatInt(g.loads.Add(1), 10)), nil
+}
+
+// 等待后台刷新完成，返回最后一次读到的值
+func waitValue(t *testing.T, gee *Group, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, err := gee.Get(key)
+		if err == nil && v.String() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s=%s, got %q (err %v)", key, want, v.String(), err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	getter := &versionGetter{}
+	gee := NewGroup("stale", 2<<10, getter, WithTTL(30*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-v1" {
+		t.Fatalf("expected Tom-v1, got %q", v.String())
+	}
+
+	// 过期之后仍然立即返回旧值，同时在后台刷新
+	time.Sleep(40 * time.Millisecond)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom-v1" {
+		t.Fatalf("expected stale Tom-v1, got %q (err %v)", v.String(), err)
+	}
+	waitValue(t, gee, "Tom", "Tom-v2")
+	s := gee.Stats()
+	if s.StaleHits.Get() < 1 || s.Refreshes.Get() != 1 || getter.loads.Load() != 2 {
+		t.Fatalf("unexpected stale hits %d refreshes %d loads %d",
+			s.StaleHits.Get(), s.Refreshes.Get(), getter.loads.Load())
+	}
+
+	// 超过maxStale的值不再返回，直接重新加载
+	getter = &versionGetter{}
+	gee = NewGroup("stale-bound", 2<<10, getter, WithTTL(10*time.Millisecond), WithStaleWhileRevalidate(10*time.Millisecond))
+	gee.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-v2" {
+		t.Fatalf("expected Tom reloaded after maxStale, got %q", v.String())
+	}
+	if s := gee.Stats(); s.StaleHits.Get() != 0 {
+		t.Fatalf("value older than maxStale was served")
+	}
+
+	// 转发来的请求读不到过期的值，否则请求方会把它当作新值再缓存一个TTL
+	getter = &versionGetter{}
+	gee = NewGroup("stale-forwarded", 2<<10, getter, WithTTL(10*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+	gee.Get("Tom")
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := gee.GetContext(withForwarded(context.Background()), "Tom"); v.String() != "Tom-v2" {
+		t.Fatalf("expected Tom reloaded for a forwarded request, got %q", v.String())
+	}
+	if s := gee.Stats(); s.StaleHits.Get() != 0 {
+		t.Fatalf("expired value was served to a forwarded request")
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	getter := &versionGetter{}
+	gee := NewGroup("refresh-ahead", 2<<10, getter, WithTTL(time.Second), WithRefreshAhead(0.05))
+	gee.Get("Tom")
+
+	// 过了ttl的5%之后读取，返回当前值并在后台提前刷新
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom-v1" {
+		t.Fatalf("expected Tom-v1 before the refresh, got %q", v.String())
+	}
+	waitValue(t, gee, "Tom", "Tom-v2")
+	if s := gee.Stats(); s.Refreshes.Get() != 1 || s.StaleHits.Get() != 0 {
+		t.Fatalf("expected one refresh and no stale hits, got %d and %d", s.Refreshes.Get(), s.StaleHits.Get())
+	}
+}
+
+func TestReplicaSetRemove(t *testing.T) {
+	owner, replica := newFakePeer(), newFakePeer()
+	gee := NewGroup("replica-set-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithReplication(3))
+	// 本机不是归属节点，owner为主节点，owner和replica保存副本
+	gee.Register(fakeReplicaPicker{owners: []PeerGetter{owner}, replicas: []PeerGetter{owner, replica}})
+
+	// Set写入主节点，并同步更新其他归属节点上的副本
+	if err := gee.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if string(owner.store["Tom"]) != "630" || string(replica.pushed["Tom"]) != "630" {
+		t.Fatalf("expected Tom=630 on the owner and the replica, got %q and %q", owner.store["Tom"], replica.pushed["Tom"])
+	}
+	if _, ok := owner.pushed["Tom"]; ok {
+		t.Fatalf("the owner was already updated by Set")
+	}
+
+	// 本机作为其他归属节点保存的副本也不能再被读到
+	gee.pushLocally("Sam", []byte("567"))
+	if err := gee.Set("Sam", []byte("568")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.lookupCache(context.Background(), "Sam"); ok {
+		t.Fatalf("local replica of Sam should be dropped by Set")
+	}
+
+	// Remove删除所有副本
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := replica.pushed["Tom"]; ok {
+		t.Fatalf("replica of Tom should be removed")
+	}
+}
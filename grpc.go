@@ -0,0 +1,271 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/consistenthash"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// GRPCPool 与 HTTPPool 作用相同，但节点之间使用 cachepb.proto 中的 GroupCache 服务通信
+// 它实现了 PeerPicker，通过 RegisterServer 对外提供 GroupCache 服务
+type GRPCPool struct {
+	self     string            // 本机的地址，例如 localhost:8001
+	dialOpts []grpc.DialOption // 连接远端节点时使用的选项
+
+	mu   sync.Mutex                            // 保证节点的更新串行执行
+	view atomic.Pointer[peerView[*grpcGetter]] // 远端服务节点的快照，读取时不需要加锁
+}
+
+// NewGRPCPool initializes a gRPC pool for peers, connections to the peers
+// are insecure unless dialOpts carries other transport credentials
+func NewGRPCPool(self string, dialOpts ...grpc.DialOption) *GRPCPool {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	p := &GRPCPool{
+		self:     self,
+		dialOpts: dialOpts,
+	}
+	p.view.Store(newPeerView[*grpcGetter](nil))
+	return p
+}
+
+// Log to record the request history
+func (p *GRPCPool) Log(format string, args ...interface{}) {
+	log.Printf("[grpc server %s] %s", p.self, fmt.Sprintf(format, args...))
+}
+
+// RegisterServer registers the GroupCache service of the pool on s
+func (p *GRPCPool) RegisterServer(s *grpc.Server) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{pool: p})
+}
+
+// Set replaces the pool's list of peers
+// 每个远端节点复用同一个 grpc.ClientConn，连接在第一次请求时才建立
+// 被移除节点的连接会被关闭
+func (p *GRPCPool) Set(peers ...string) error {
+	return p.SetPeers(peersOf(peers)...)
+}
+
+// SetPeers is like Set but every peer carries a weight
+func (p *GRPCPool) SetPeers(peers ...Peer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var created []*grpcGetter
+	next, stale, err := p.view.Load().with(peers, func(peer string) (*grpcGetter, error) {
+		conn, err := grpc.NewClient(peer, p.dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+		g := &grpcGetter{conn: conn, client: pb.NewGroupCacheClient(conn)}
+		created = append(created, g)
+		return g, nil
+	})
+	if err != nil {
+		closeGetters(created)
+		return err
+	}
+	p.view.Store(next)
+	closeGetters(stale)
+	return nil
+}
+
+// SetPlacement switches the algorithm which maps keys to peers,
+// see HTTPPool.SetPlacement
+func (p *GRPCPool) SetPlacement(newPlacement func() consistenthash.Placement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.view.Store(p.view.Load().withPlacement(newPlacement))
+}
+
+// Remove takes peers out of the pool and closes the connections to them
+func (p *GRPCPool) Remove(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next, stale := p.view.Load().without(peers)
+	p.view.Store(next)
+	closeGetters(stale)
+}
+
+// Close closes the connections to all the peers
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.view.Swap(newPeerView[*grpcGetter](p.view.Load().newPlacement))
+	for _, g := range old.getters {
+		g.conn.Close()
+	}
+	return nil
+}
+
+// 正在进行中的请求在连接关闭后会返回错误，由Group转为本地加载
+func closeGetters(getters []*grpcGetter) {
+	for _, g := range getters {
+		g.conn.Close()
+	}
+}
+
+// PickPeer picks a peer according to key
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	return p.view.Load().pick(p.self, key)
+}
+
+// PickPeers returns up to n owners of key in preference order, see ReplicaPicker
+func (p *GRPCPool) PickPeers(key string, n int) []PeerGetter {
+	return p.view.Load().pickN(p.self, key, n)
+}
+
+// PickOwner returns the owner of key regardless of loads, see ReplicaPicker
+func (p *GRPCPool) PickOwner(key string) (PeerGetter, bool) {
+	return p.view.Load().owner(p.self, key)
+}
+
+// PickReplicas returns the owners of key other than self, see ReplicaPicker
+func (p *GRPCPool) PickReplicas(key string, n int) []PeerGetter {
+	return p.view.Load().replicas(p.self, key, n)
+}
+
+// GetAll returns the getters of all the peers except self
+func (p *GRPCPool) GetAll() []PeerGetter {
+	return p.view.Load().all(p.self)
+}
+
+// grpcServer 实现 GroupCache 服务，由于服务的Set方法与 GRPCPool.Set 重名，单独定义
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	pool *GRPCPool
+}
+
+// 查找请求对应的group，找不到时返回 InvalidArgument，NotFound 只表示key不存在
+func lookupGroup(name string) (*Group, error) {
+	group := GetGroup(name)
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "not match the group %s", name)
+	}
+	return group, nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	s.pool.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.stats.ServerRequests.Add(1)
+
+	if in.GetForwarded() {
+		ctx = withForwarded(ctx)
+	}
+	val, err := group.GetContext(ctx, in.GetKey())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{Value: val.ByteSlice()}, nil
+}
+
+// 远端节点发来的Set请求只作用在本机上
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*emptypb.Empty, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if in.GetKeyOnly() {
+		group.addedElsewhere(in.GetKey())
+	} else {
+		group.setLocally(in.GetKey(), in.GetValue())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// 远端节点发来的Remove请求只作用在本机上
+func (s *grpcServer) Remove(ctx context.Context, in *pb.Request) (*emptypb.Empty, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &emptypb.Empty{}, nil
+}
+
+// 批量查找，每个加载失败的key的错误写在返回的Errors中
+func (s *grpcServer) GetMany(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	s.pool.Log("GetMany %s %d keys", in.GetGroup(), len(in.GetKeys()))
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if in.GetForwarded() {
+		ctx = withForwarded(ctx)
+	}
+	return group.serveMany(ctx, in.GetKeys()), nil
+}
+
+// 其他归属节点推送来的副本只写入本机的缓存
+func (s *grpcServer) Push(ctx context.Context, in *pb.SetRequest) (*emptypb.Empty, error) {
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.pushLocally(in.GetKey(), in.GetValue())
+	return &emptypb.Empty{}, nil
+}
+
+// 提供远端访问节点的功能
+// 以客户端作为角色，对同一个节点的请求复用同一个连接
+type grpcGetter struct {
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+}
+
+func (g *grpcGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		// NotFound 只用于key不存在，找不到group时为 InvalidArgument
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s", ErrNotFound, in.GetKey())
+		}
+		return err
+	}
+	out.Value = res.GetValue()
+	return nil
+}
+
+func (g *grpcGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	_, err := g.client.Set(ctx, in)
+	return err
+}
+
+func (g *grpcGetter) Remove(ctx context.Context, in *pb.Request) error {
+	_, err := g.client.Remove(ctx, in)
+	return err
+}
+
+func (g *grpcGetter) Push(ctx context.Context, in *pb.SetRequest) error {
+	_, err := g.client.Push(ctx, in)
+	return err
+}
+
+func (g *grpcGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	res, err := g.client.GetMany(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Values = res.GetValues()
+	out.Errors = res.GetErrors()
+	out.NotFound = res.GetNotFound()
+	return nil
+}
@@ -0,0 +1,80 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestGRPCPool(t *testing.T) {
+	loads := 0
+	NewGroup("grpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		loads++
+		return []byte("db-" + key), nil
+	}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	NewGRPCPool(lis.Addr().String()).RegisterServer(server)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// 客户端所在的节点只知道远端节点，所有key都会交给远端节点
+	client := NewGRPCPool("127.0.0.1:1")
+	defer client.Close()
+	if err := client.Set(lis.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("expected remote peer for Tom")
+	}
+
+	ctx := context.Background()
+	out := &pb.Response{}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc", Key: "Tom"}, out); err != nil {
+		t.Fatalf("remote get failed: %v", err)
+	}
+	if string(out.Value) != "db-Tom" || loads != 1 {
+		t.Fatalf("expected Tom=db-Tom, got %q (loads %d)", out.Value, loads)
+	}
+
+	if err := peer.Set(ctx, &pb.SetRequest{Group: "grpc", Key: "Tom", Value: []byte("630")}); err != nil {
+		t.Fatalf("remote set failed: %v", err)
+	}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc", Key: "Tom"}, out); err != nil || string(out.Value) != "630" {
+		t.Fatalf("expected Tom=630 after set, got %q (err %v)", out.Value, err)
+	}
+
+	if err := peer.Remove(ctx, &pb.Request{Group: "grpc", Key: "Tom"}); err != nil {
+		t.Fatalf("remote remove failed: %v", err)
+	}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc", Key: "Tom"}, out); err != nil || loads != 2 {
+		t.Fatalf("expected Tom reloaded after remove (loads %d, err %v)", loads, err)
+	}
+
+	if err := peer.Get(ctx, &pb.Request{Group: "no-such-group", Key: "Tom"}, out); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a failure other than ErrNotFound for unknown group, got %v", err)
+	}
+	if err := peer.Get(ctx, &pb.Request{Group: "grpc", Key: "missing"}, out); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing, got %v", err)
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(ctx, &pb.BatchRequest{Group: "grpc", Keys: []string{"Tom", "Jack"}}, batch); err != nil {
+		t.Fatalf("remote GetMany failed: %v", err)
+	}
+	if string(batch.Values["Tom"]) != "db-Tom" || string(batch.Values["Jack"]) != "db-Jack" || loads != 3 {
+		t.Fatalf("unexpected batch %v (loads %d)", batch.Values, loads)
+	}
+}
@@ -0,0 +1,246 @@
+package geecache
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 健康检查的接口，路径为 basePath + healthPath
+const healthPath = "_health"
+
+const (
+	defaultHealthInterval         = 5 * time.Second
+	defaultHealthTimeout          = time.Second
+	defaultHealthFailureThreshold = 3
+	defaultHealthRecoverThreshold = 1
+)
+
+// PeerState is the health of a peer as seen by the health checker
+type PeerState int
+
+const (
+	// PeerUp peers are on the ring and receive requests
+	PeerUp PeerState = iota
+	// PeerDown peers failed too many probes in a row and are temporarily
+	// taken out of the ring, their keys move to the remaining peers
+	PeerDown
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerUp:
+		return "up"
+	case PeerDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// HealthCheckOptions configures HTTPPool.StartHealthCheck, zero values
+// fall back to the defaults
+type HealthCheckOptions struct {
+	Interval         time.Duration // 两轮探测之间的间隔，默认5s
+	Timeout          time.Duration // 单次探测的超时时间，默认1s
+	FailureThreshold int           // 连续失败多少次后判定节点不可用，默认3
+	RecoverThreshold int           // 不可用的节点连续成功多少次后恢复，默认1
+
+	// OnStateChange is called from the checker goroutine, one call at a
+	// time, after a peer has been taken out of or put back on the ring
+	OnStateChange func(peer string, state PeerState)
+}
+
+// healthChecker 定期探测每个节点的 _health 接口
+type healthChecker struct {
+	opts   HealthCheckOptions
+	client *http.Client
+	stop   chan struct{}
+	done   chan struct{}
+
+	// 以下字段只在checker的goroutine中访问
+	streak map[string]int // 节点连续失败（在线时）或连续成功（下线时）的次数
+}
+
+// serveHealth answers the probes of the other peers
+func (p *HTTPPool) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !p.checkMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// StartHealthCheck probes every peer periodically through its health
+// endpoint. A peer which fails FailureThreshold probes in a row is taken
+// out of the ring until it passes RecoverThreshold probes in a row again.
+// It panics if the health check is already running
+func (p *HTTPPool) StartHealthCheck(opts HealthCheckOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultHealthInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHealthTimeout
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultHealthFailureThreshold
+	}
+	if opts.RecoverThreshold <= 0 {
+		opts.RecoverThreshold = defaultHealthRecoverThreshold
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.health != nil {
+		panic("health check started more than once")
+	}
+	// 与请求远端节点共用transport，但使用探测自己的超时时间
+	h := &healthChecker{
+		opts:   opts,
+		client: &http.Client{Transport: p.client.Transport, Timeout: opts.Timeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		streak: make(map[string]int),
+	}
+	p.health = h
+	go p.runHealthCheck(h)
+}
+
+// StopHealthCheck stops the health check and puts the peers it took out
+// back on the ring
+func (p *HTTPPool) StopHealthCheck() {
+	p.mu.Lock()
+	h := p.health
+	p.health = nil
+	p.mu.Unlock()
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = nil
+	p.rebuild()
+}
+
+// PeerStates returns the health of every peer except self, peers are
+// up until the health check marks them down
+func (p *HTTPPool) PeerStates() map[string]PeerState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	states := make(map[string]PeerState, len(p.peers))
+	for _, peer := range p.peers {
+		if peer.Addr == p.self {
+			continue
+		}
+		if p.down[peer.Addr] {
+			states[peer.Addr] = PeerDown
+		} else {
+			states[peer.Addr] = PeerUp
+		}
+	}
+	return states
+}
+
+func (p *HTTPPool) runHealthCheck(h *healthChecker) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			p.probeAll(h)
+		}
+	}
+}
+
+// 并发探测所有节点，再依次处理探测结果
+func (p *HTTPPool) probeAll(h *healthChecker) {
+	states := p.PeerStates()
+	results := make(map[string]bool, len(states))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for peer := range states {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			ok := h.probe(peer + p.basePath + healthPath)
+			mu.Lock()
+			results[peer] = ok
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+
+	for peer, ok := range results {
+		state := states[peer]
+		if (state == PeerUp) == ok { // 在线的节点探测成功，或下线的节点探测失败
+			delete(h.streak, peer)
+			continue
+		}
+		h.streak[peer]++
+		threshold := h.opts.FailureThreshold
+		if state == PeerDown {
+			threshold = h.opts.RecoverThreshold
+		}
+		if h.streak[peer] < threshold {
+			continue
+		}
+		delete(h.streak, peer)
+		if next, changed := p.markPeer(peer, state == PeerUp); changed {
+			p.Log("peer %s is %s", peer, next)
+			if h.opts.OnStateChange != nil {
+				h.opts.OnStateChange(peer, next)
+			}
+		}
+	}
+	// 已经不在节点集合中的节点不再记录
+	for peer := range h.streak {
+		if _, ok := states[peer]; !ok {
+			delete(h.streak, peer)
+		}
+	}
+}
+
+func (h *healthChecker) probe(url string) bool {
+	res, err := h.client.Get(url)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	return res.StatusCode == http.StatusOK
+}
+
+// 将节点标记为下线并从环上摘除，或者标记为上线并放回环上
+// 节点在探测期间被移出节点集合时不做任何修改
+func (p *HTTPPool) markPeer(peer string, down bool) (PeerState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	member := false
+	for _, m := range p.peers {
+		if m.Addr == peer {
+			member = true
+			break
+		}
+	}
+	if !member || p.down[peer] == down {
+		return PeerUp, false
+	}
+	if down {
+		if p.down == nil {
+			p.down = make(map[string]bool)
+		}
+		p.down[peer] = true
+		p.rebuild()
+		return PeerDown, true
+	}
+	delete(p.down, peer)
+	p.rebuild()
+	return PeerUp, true
+}
@@ -0,0 +1,156 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgePercentile = 0.95
+	defaultHedgeMaxDelay   = 100 * time.Millisecond
+
+	hedgeWindowSize = 256 // 计算分位数时保留的最近请求耗时的个数
+	hedgeMinSamples = 20  // 样本少于该值时使用 MaxDelay
+)
+
+// HedgeOptions configures hedged peer requests, see WithHedging. Zero
+// values fall back to the defaults
+type HedgeOptions struct {
+	// Percentile of the recent peer latencies to wait for before the
+	// hedge request is sent, 0.95 by default
+	Percentile float64
+	MinDelay   time.Duration // 等待时间的下限
+	MaxDelay   time.Duration // 等待时间的上限，样本不足时也使用该值，默认100ms
+}
+
+// hedger 记录最近远端请求的耗时，决定发出对冲请求之前等待多久
+type hedger struct {
+	opts HedgeOptions
+
+	mu      sync.Mutex
+	samples []time.Duration // 环形缓冲区
+	next    int
+}
+
+func newHedger(opts HedgeOptions) *hedger {
+	if opts.Percentile <= 0 || opts.Percentile > 1 {
+		opts.Percentile = defaultHedgePercentile
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultHedgeMaxDelay
+	}
+	if opts.MinDelay > opts.MaxDelay {
+		opts.MinDelay = opts.MaxDelay
+	}
+	return &hedger{opts: opts, samples: make([]time.Duration, 0, hedgeWindowSize)}
+}
+
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeWindowSize {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % hedgeWindowSize
+}
+
+// 返回最近请求耗时的 Percentile 分位数，限制在 [MinDelay, MaxDelay] 之间
+func (h *hedger) delay() time.Duration {
+	h.mu.Lock()
+	if len(h.samples) < hedgeMinSamples {
+		h.mu.Unlock()
+		return h.opts.MaxDelay
+	}
+	sorted := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	d := sorted[int(h.opts.Percentile*float64(len(sorted)-1))]
+	if d < h.opts.MinDelay {
+		d = h.opts.MinDelay
+	}
+	if d > h.opts.MaxDelay {
+		d = h.opts.MaxDelay
+	}
+	return d
+}
+
+type hedgeResult struct {
+	value ByteView
+	err   error
+	peer  PeerGetter // 为nil时结果来自本地的getter
+	hedge bool       // 结果是否来自对冲请求
+}
+
+// hedgedGet 向主节点发出请求，主节点在对冲延迟内没有返回时再向hedge发出请求，
+// hedge为nil时使用本地的getter，采用先成功的结果并取消另一个请求。
+// hedged 表示是否发出了对冲请求
+func (g *Group) hedgedGet(ctx context.Context, key string, primary, hedge PeerGetter) (value ByteView, hedged bool, err error) {
+	results := make(chan hedgeResult, 2) // 带缓冲，被取消的请求返回时不会阻塞
+	pctx, pcancel := context.WithCancel(ctx)
+	defer pcancel()
+	go func() {
+		v, err := g.getFromPeer(pctx, primary, key)
+		results <- hedgeResult{value: v, err: err, peer: primary}
+	}()
+
+	timer := time.NewTimer(g.hedge.delay())
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		if r.err != nil {
+			if !errors.Is(r.err, ErrNotFound) {
+				g.peerFailed(primary, r.err)
+			}
+			return ByteView{}, false, r.err
+		}
+		g.peerLoaded(key, r.value)
+		return r.value, false, nil
+	case <-timer.C:
+	}
+
+	g.stats.HedgedRequests.Add(1)
+	hctx, hcancel := context.WithCancel(ctx)
+	defer hcancel()
+	go func() {
+		var v ByteView
+		var err error
+		if hedge == nil {
+			v, err = g.getLocally(hctx, key)
+		} else {
+			v, err = g.getFromPeer(hctx, hedge, key)
+		}
+		results <- hedgeResult{value: v, err: err, peer: hedge, hedge: true}
+	}()
+
+	// 返回时通过defer取消还没有结束的请求
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			if r.hedge {
+				g.stats.HedgeWins.Add(1)
+			}
+			if r.peer == nil {
+				g.localLoaded(key, r.value)
+			} else {
+				g.peerLoaded(key, r.value)
+			}
+			return r.value, true, nil
+		}
+		if errors.Is(r.err, ErrNotFound) { // key不存在，另一个请求的结果也一样
+			return ByteView{}, true, r.err
+		}
+		if r.peer == nil {
+			g.localFailed(r.err)
+		} else {
+			g.peerFailed(r.peer, r.err)
+		}
+		err = r.err
+	}
+	return ByteView{}, true, err
+}
@@ -1,16 +1,24 @@
-package main
+package geecache
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	pb "geeCache/cachepb"
-	"geeCache/consistenthash"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/consistenthash"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"golang.org/x/net/http2"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,25 +26,181 @@ import (
 const defaultBasePath = "/_geecache/"
 const defaultReplicas = 50
 
+// Set和Push请求body的默认大小上限
+const defaultMaxBodyBytes = 32 << 20
+
+// 每个远端节点保持的空闲连接数，http.DefaultTransport 只保持2个
+const defaultMaxIdleConnsPerHost = 32
+
+// 其他归属节点推送副本的接口，路径为 basePath + pushPrefix + group/key
+const pushPrefix = "_push/"
+
+// 批量查找的接口，路径为 basePath + batchPrefix + group，body为pb.BatchRequest
+const batchPrefix = "_batch/"
+
+// key不存在时404响应带有该header，与找不到group或路径的404区分开
+const notFoundHeader = "X-Geecache-Not-Found"
+
+// Get请求带有该header时对应 pb.Request 的 Forwarded，本机直接加载不再转发
+const forwardedHeader = "X-Geecache-Forwarded"
+
 // 服务端
 // 集成一致性哈希以及以客户端访问远端节点的能力
 type HTTPPool struct {
 	self     string
 	basePath string // 为了与其他服务进行区分
 
-	mu          sync.Mutex             // guards the httpGetter
-	peers       *consistenthash.Map    // 一致性哈希映射器
-	httpGetters map[string]*httpGetter // 远端服务节点
+	client  *http.Client  // 向远端节点发送请求
+	timeout time.Duration // 单个请求的超时时间，0表示只受调用者ctx的限制
+
+	maxBodyBytes int64 // 远端节点发来的请求body的大小上限
+
+	placement func() consistenthash.Placement // 由Replicas和HashFn生成的默认placement
+
+	mu   sync.Mutex                            // 保证节点的更新串行执行
+	view atomic.Pointer[peerView[*httpGetter]] // 远端服务节点的快照，读取时不需要加锁
+
+	// 以下字段在mu的保护下修改
+	peers  []Peer          // Set/SetPeers设置的节点，包括被健康检查暂时摘除的节点
+	down   map[string]bool // 被健康检查判定为不可用、暂时从环上摘除的节点
+	health *healthChecker  // 未开启健康检查时为nil
+}
+
+// HTTPPoolOptions are the configurations of a HTTPPool
+type HTTPPoolOptions struct {
+	// Client sends the requests to the peers. When nil a client is built
+	// from Transport, MaxIdleConnsPerHost and HTTP2
+	Client *http.Client
+
+	// Transport is the RoundTripper of the default client, e.g. one which
+	// records metrics. When nil a http.Transport is used
+	Transport http.RoundTripper
+
+	// Timeout bounds every request to a peer, on top of the caller's
+	// context. 0 means no timeout
+	Timeout time.Duration
+
+	// MaxIdleConnsPerHost is the number of keep-alive connections kept to
+	// each peer by the default transport, 32 by default
+	MaxIdleConnsPerHost int
+
+	// HTTP2 makes the default transport speak HTTP/2: negotiated through
+	// TLS for https peers and with prior knowledge (h2c) for http peers,
+	// whose servers must then accept h2c, e.g. through
+	// golang.org/x/net/http2/h2c
+	HTTP2 bool
+
+	// BasePath is the path prefix the pool serves under and requests the
+	// peers with, "/_geecache/" by default. All the peers must use the same
+	BasePath string
+
+	// Replicas is the number of virtual nodes of each peer on the default
+	// hash ring, 50 by default
+	Replicas int
+
+	// HashFn hashes the keys and virtual nodes on the default hash ring,
+	// crc32.ChecksumIEEE by default. All the peers must use the same
+	HashFn consistenthash.Hash
+
+	// MaxBodyBytes limits the body of the Set and Push requests the pool
+	// serves, larger ones get 413. 32MB by default
+	MaxBodyBytes int64
 }
 
 // NewHTTPPol initializes an HTTP pool for peers
 func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:        self,
-		basePath:    defaultBasePath,
-		peers:       consistenthash.New(defaultReplicas, nil),
-		httpGetters: make(map[string]*httpGetter),
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts initializes an HTTP pool for peers with the given
+// options, nil options are the same as NewHTTPPool
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	var opts HTTPPoolOptions
+	if o != nil {
+		opts = *o
+	}
+	p := &HTTPPool{
+		self:     self,
+		basePath: opts.basePath(),
+		client:   opts.Client,
+		timeout:  opts.Timeout,
+
+		maxBodyBytes: opts.MaxBodyBytes,
+	}
+	if p.maxBodyBytes <= 0 {
+		p.maxBodyBytes = defaultMaxBodyBytes
+	}
+	if p.client == nil {
+		p.client = &http.Client{Transport: opts.transport()}
 	}
+	replicas, hashFn := opts.Replicas, opts.HashFn
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	p.placement = func() consistenthash.Placement {
+		return consistenthash.New(replicas, hashFn)
+	}
+	p.view.Store(newPeerView[*httpGetter](p.placement))
+	return p
+}
+
+// 保证BasePath以/开头和结尾
+func (o *HTTPPoolOptions) basePath() string {
+	if o.BasePath == "" {
+		return defaultBasePath
+	}
+	path := o.BasePath
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// 默认client使用的transport
+func (o *HTTPPoolOptions) transport() http.RoundTripper {
+	if o.Transport != nil {
+		return o.Transport
+	}
+	maxIdle := o.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = maxIdle
+	if maxIdle > t.MaxIdleConns {
+		t.MaxIdleConns = maxIdle
+	}
+	if !o.HTTP2 {
+		return t
+	}
+	t.ForceAttemptHTTP2 = true
+	return &http2Transport{
+		tls: t,
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			// h2c 不经过TLS，直接建立TCP连接
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// http2Transport 对https节点通过TLS协商HTTP/2，对http节点直接使用h2c
+type http2Transport struct {
+	tls *http.Transport
+	h2c *http2.Transport
+}
+
+func (t *http2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
 }
 
 // Log to record the request history
@@ -44,45 +208,139 @@ func (p *HTTPPool) Log(format string, args ...interface{}) {
 	log.Printf("[server %s] %s", p.self, fmt.Sprintf(format, args...))
 }
 
+// 处理请求时可能出现的错误，由 httpStatus 转换为状态码
+var (
+	errPathNotServed    = errors.New("path is not served by the pool")
+	errBadPath          = errors.New("expected <basePath>/<group>/<key>")
+	errNoGroup          = errors.New("no such group")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errBadBody          = errors.New("bad request body")
+)
+
+// httpStatus maps an error met while serving a request to the status
+// code of the response
+func httpStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errPathNotServed), errors.Is(err, errNoGroup), errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBadPath), errors.Is(err, errBadBody):
+		return http.StatusBadRequest
+	case errors.Is(err, errMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// 以err对应的状态码返回错误
+func (p *HTTPPool) fail(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		w.Header().Set(notFoundHeader, "1")
+	}
+	http.Error(w, err.Error(), httpStatus(err))
+}
+
+// 请求方法不在allow中时返回405并返回false
+func (p *HTTPPool) checkMethod(w http.ResponseWriter, r *http.Request, allow ...string) bool {
+	for _, m := range allow {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	p.fail(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	return false
+}
+
 // to implement the http.Handler
 // 服务端
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !strings.HasPrefix(r.URL.Path, defaultBasePath) {
-		panic("not serve path " + r.URL.Path)
+	if !strings.HasPrefix(r.URL.Path, p.basePath) {
+		p.fail(w, fmt.Errorf("%w: %s", errPathNotServed, r.URL.Path))
+		return
+	}
+	// 获取basePath后的接口
+	rest := r.URL.Path[len(p.basePath):]
+	if rest == healthPath { // 健康检查请求很频繁，不记录日志
+		p.serveHealth(w, r)
+		return
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
 
-	// 获取defaultBasePath后的接口
-	parts := strings.SplitN(r.URL.Path[len(defaultBasePath):], "/", 2)
+	if strings.HasPrefix(rest, batchPrefix) {
+		p.serveBatch(w, r, rest[len(batchPrefix):])
+		return
+	}
+
+	push := strings.HasPrefix(rest, pushPrefix)
+	if push {
+		rest = rest[len(pushPrefix):]
+	}
+	parts := strings.SplitN(rest, "/", 2)
 	// 检查是否符合服务规则
-	if len(parts) != 2 {
+	if len(parts) != 2 || parts[0] == "" {
 		p.Log("not found the group or key %s", r.URL.Path)
-		http.Error(w, "not found the group or key ", http.StatusBadRequest)
+		p.fail(w, fmt.Errorf("%w: %s", errBadPath, r.URL.Path))
 		return
 	}
 
 	groupName := parts[0]
 	key := parts[1]
 
+	if push {
+		if !p.checkMethod(w, r, http.MethodPost) {
+			return
+		}
+	} else if !p.checkMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
 	// 从本地获取缓存数据
 	// 获取缓存组
 	group := GetGroup(groupName)
 	if group == nil {
-		http.Error(w, "not match the group", http.StatusNotFound)
+		p.fail(w, fmt.Errorf("%w: %s", errNoGroup, groupName))
 		return
 	}
 
-	// 尝试获取key对应的value
-	val, err := group.Get(key)
+	switch {
+	case push:
+		p.servePush(w, r, group, key)
+	case r.Method == http.MethodGet:
+		p.serveGet(w, r, group, key)
+	case r.Method == http.MethodPut:
+		p.serveSet(w, r, group, key)
+	case r.Method == http.MethodDelete:
+		// 远端节点发来的删除请求只作用在本机上
+		group.removeLocally(key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (p *HTTPPool) serveGet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	group.stats.ServerRequests.Add(1)
+	ctx := r.Context()
+	if r.Header.Get(forwardedHeader) != "" {
+		ctx = withForwarded(ctx)
+	}
+	// 尝试获取key对应的value，请求方断开或超时后不再等待
+	val, err := group.GetContext(ctx, key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.fail(w, err)
 		return
 	}
 
 	// 将缓存得到的缓存结果转为二进制然后，将这个二进制bytes返回
 	body, err := proto.Marshal(&pb.Response{Value: val.ByteSlice()})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.fail(w, err)
 		return
 	}
 
@@ -90,57 +348,245 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-// 添加远端服务的节点
+// 远端节点发来的Set请求，body为pb.SetRequest
+// KeyOnly时key写入了其他节点，只删除本机的拷贝并让过滤器记住key
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	in, err := p.readSetRequest(w, r)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	if in.GetKeyOnly() {
+		group.addedElsewhere(key)
+	} else {
+		group.setLocally(key, in.GetValue())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 其他归属节点推送来的副本，body与Set请求相同
+func (p *HTTPPool) servePush(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	in, err := p.readSetRequest(w, r)
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	group.pushLocally(key, in.GetValue())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 远端节点发来的批量查找请求
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, groupName string) {
+	if !p.checkMethod(w, r, http.MethodPost) {
+		return
+	}
+	if groupName == "" || strings.Contains(groupName, "/") {
+		p.fail(w, fmt.Errorf("%w: %s", errBadPath, r.URL.Path))
+		return
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		p.fail(w, fmt.Errorf("%w: %s", errNoGroup, groupName))
+		return
+	}
+	in := &pb.BatchRequest{}
+	if err := p.readBody(w, r, in); err != nil {
+		p.fail(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if in.GetForwarded() {
+		ctx = withForwarded(ctx)
+	}
+	body, err := proto.Marshal(group.serveMany(ctx, in.GetKeys()))
+	if err != nil {
+		p.fail(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 读取body中的pb.SetRequest
+func (p *HTTPPool) readSetRequest(w http.ResponseWriter, r *http.Request) (*pb.SetRequest, error) {
+	in := &pb.SetRequest{}
+	if err := p.readBody(w, r, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// 将body解析到m中，body超过maxBodyBytes时返回 *http.MaxBytesError
+func (p *HTTPPool) readBody(w http.ResponseWriter, r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, p.maxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(body, m); err != nil {
+		return fmt.Errorf("%w: %v", errBadBody, err)
+	}
+	return nil
+}
+
+// Set replaces the pool's list of peers
+// 重复调用只会替换节点集合，不会产生重复的虚拟节点；仍在集合中的节点沿用原来的httpGetter
 func (p *HTTPPool) Set(peers ...string) {
+	p.SetPeers(peersOf(peers)...)
+}
+
+// SetPeers is like Set but every peer carries a weight, a peer with a
+// larger weight owns a proportionally larger share of the keys
+func (p *HTTPPool) SetPeers(peers ...Peer) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// 添加物理节点
+	p.peers = append([]Peer(nil), peers...)
+	p.rebuild()
+}
+
+// 根据节点集合重新生成快照，被健康检查摘除的节点不放到环上
+// 调用者需要持有mu
+func (p *HTTPPool) rebuild() {
+	var active []Peer
+	down := make(map[string]bool, len(p.down))
+	for _, peer := range p.peers {
+		if p.down[peer.Addr] {
+			down[peer.Addr] = true
+			continue
+		}
+		active = append(active, peer)
+	}
+	p.down = down // 已经不在节点集合中的节点不再记录
+
 	// 先要将新物理节点添加到一致性哈希的映射上
 	// 同时要记录物理节点名到服务名的映射
-	p.peers.Add(peers...)
-	// 是否需要检查相同的peer的情况，如果设置了相同的peer可能需要panic或错误处理
+	next, _, _ := p.view.Load().with(active, func(peer string) (*httpGetter, error) {
+		g := newHttpGetter(peer)
+		g.basePath = p.basePath
+		g.client = p.client
+		g.timeout = p.timeout
+		return g, nil
+	})
+	p.view.Store(next)
+}
+
+// SetPlacement switches the algorithm which maps keys to peers, e.g.
+// consistenthash.NewMaglev. newPlacement is called on every membership
+// change, so it must return a new empty Placement each time. nil goes
+// back to the hash ring configured by Replicas and HashFn
+func (p *HTTPPool) SetPlacement(newPlacement func() consistenthash.Placement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if newPlacement == nil {
+		newPlacement = p.placement
+	}
+	p.view.Store(p.view.Load().withPlacement(newPlacement))
+}
+
+// Remove takes peers out of the pool, the keys they owned move to the
+// remaining peers
+func (p *HTTPPool) Remove(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	removed := make(map[string]bool, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = newHttpGetter(peer)
+		removed[peer] = true
 	}
+	var rest []Peer
+	for _, peer := range p.peers {
+		if !removed[peer.Addr] {
+			rest = append(rest, peer)
+		}
+	}
+	p.peers = rest
+	p.rebuild()
 }
 
 // 调用一致性缓存获取key到realnode的映射，然后向realnode转发请求
 // 或者这个realnode是本机的，可以直接访问本机的group
+// 未注册远端节点或peer直接本地的HTTPPool，则返回false；找不到服务的节点或直接等于本机的节点应该由本机的group处理
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	peer := p.peers.Get(key)
-	if peer == "" || peer == p.self { // 未注册远端节点或peer直接本地的HTTPPool，则返回false；找不到服务的节点或直接等于本机的节点应该由本机的group处理
-		// 应该对应Group处理流程的代码理解比较合适
-		return nil, false
-	}
+	return p.view.Load().pick(p.self, key)
+}
+
+// PickPeers returns up to n owners of key in preference order, see ReplicaPicker
+func (p *HTTPPool) PickPeers(key string, n int) []PeerGetter {
+	return p.view.Load().pickN(p.self, key, n)
+}
 
-	hg, ok := p.httpGetters[peer]
-	return hg, ok
+// PickOwner returns the owner of key regardless of loads, see ReplicaPicker
+func (p *HTTPPool) PickOwner(key string) (PeerGetter, bool) {
+	return p.view.Load().owner(p.self, key)
+}
+
+// PickReplicas returns the owners of key other than self, see ReplicaPicker
+func (p *HTTPPool) PickReplicas(key string, n int) []PeerGetter {
+	return p.view.Load().replicas(p.self, key, n)
+}
+
+// GetAll returns the getters of all the peers except self
+func (p *HTTPPool) GetAll() []PeerGetter {
+	return p.view.Load().all(p.self)
 }
 
 // 提供远端访问节点的功能
 // 以客户端作为角色
 type httpGetter struct {
-	baseURL string // remote node's ip:port
+	baseURL  string     // remote node's ip:port
+	basePath string     // 远端节点提供服务的路径前缀，与本机的basePath相同
+	latency  *histogram // Get 请求的耗时
+
+	client  *http.Client  // 由HTTPPool设置，默认为 http.DefaultClient
+	timeout time.Duration // 单个请求的超时时间，0表示不限制
 }
 
 func newHttpGetter(baseURL string) *httpGetter {
-	return &httpGetter{baseURL: baseURL}
+	return &httpGetter{
+		baseURL:  baseURL,
+		basePath: defaultBasePath,
+		latency:  newHistogram(defaultLatencyBuckets),
+		client:   http.DefaultClient,
+	}
+}
+
+// 为请求加上超时时间
+func (s *httpGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// 向远端节点请求group中key的地址
+func (s *httpGetter) keyURL(group, key string) string {
+	return fmt.Sprintf("%v%v/%v",
+		s.baseURL+s.basePath,   // basePath 作为跟路由表示请求的是cache服务
+		url.QueryEscape(group), // url.QueryEscape 用于对字符串进行URL编码，用于在URL中嵌入特殊字符，将非数字字符转化为百分号后跟两位十六进制数，使得这些字符可以安全地被包含在url中
+		url.QueryEscape(key),
+	)
 }
 
 // Query from remote node
 // 将请求要用到的放在pb.Request中
 // 请求得到的结果放在pb.Response
-func (s *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+func (s *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
 	// 向远端节点的请求地址
-	m := fmt.Sprintf("%v%v/%v",
-		s.baseURL+defaultBasePath,      // defaultBasePath 作为跟路由表示请求的是cache服务
-		url.QueryEscape(in.GetGroup()), // url.QueryEscape 用于对字符串进行URL编码，用于在URL中嵌入特殊字符，将非数字字符转化为百分号后跟两位十六进制数，使得这些字符可以安全地被包含在url中
-		url.QueryEscape(in.GetKey()),
-	)
+	m := s.keyURL(in.GetGroup(), in.GetKey())
+	start := time.Now()
+	defer func() { s.latency.observe(time.Since(start)) }()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	response, err := http.Get(m)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m, nil)
+	if err != nil {
+		return err
+	}
+	if in.GetForwarded() {
+		req.Header.Set(forwardedHeader, "1")
+	}
+	response, err := s.client.Do(req)
 	if err != nil {
 		log.Printf("[m:%s] Get Error %s ", m, err.Error())
 		return err
@@ -148,7 +594,7 @@ func (s *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	defer response.Body.Close() // don't forget to close body or will be unsafe
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned:  %v", response.Status)
+		return responseError(response)
 	}
 
 	// 读取返回结果的body中的bytes
@@ -167,3 +613,111 @@ func (s *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 
 	return nil
 }
+
+// GetMany queries many keys of a group on the remote node with a POST to
+// its batch endpoint
+func (s *httpGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("proto.Marshal error: %v", err)
+	}
+	m := s.baseURL + s.basePath + batchPrefix + url.QueryEscape(in.GetGroup())
+	start := time.Now()
+	defer func() { s.latency.observe(time.Since(start)) }()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	response, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("[m:%s] GetMany Error %s ", m, err.Error())
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+	bytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("read response body: %v", err)
+	}
+	if err = proto.Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("proto.Unmarshal error: %v", err)
+	}
+	return nil
+}
+
+// Set stores the value on the remote node with a PUT request
+func (s *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	return s.send(ctx, http.MethodPut, s.keyURL(in.GetGroup(), in.GetKey()), in)
+}
+
+// Push stores a replica on the remote node with a POST to its push endpoint
+func (s *httpGetter) Push(ctx context.Context, in *pb.SetRequest) error {
+	m := fmt.Sprintf("%v%v%v/%v",
+		s.baseURL+s.basePath,
+		pushPrefix,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+	return s.send(ctx, http.MethodPost, m, in)
+}
+
+// 以pb.SetRequest为body发送请求
+func (s *httpGetter) send(ctx context.Context, method, m string, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("proto.Marshal error: %v", err)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, m, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return s.do(req)
+}
+
+// Remove deletes the key on the remote node with a DELETE request
+func (s *httpGetter) Remove(ctx context.Context, in *pb.Request) error {
+	m := s.keyURL(in.GetGroup(), in.GetKey())
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, m, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req)
+}
+
+// 发送不需要读取返回内容的请求，只检查状态码
+func (s *httpGetter) do(req *http.Request) error {
+	response, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("[m:%s] %s Error %s ", req.URL, req.Method, err.Error())
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned:  %v", response.Status)
+	}
+	return nil
+}
+
+// 将失败的响应转为错误，远端节点找不到key时返回 ErrNotFound
+// 找不到group等其他原因的404没有 notFoundHeader，不能当作key不存在
+func responseError(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) != "" {
+		return fmt.Errorf("%w: %s", ErrNotFound, res.Request.URL)
+	}
+	return fmt.Errorf("server returned:  %v", res.Status)
+}
@@ -0,0 +1,789 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/consistenthash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPGetterSetRemove(t *testing.T) {
+	loads := 0
+	NewGroup("http-set-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}))
+
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	ctx := context.Background()
+	getter := newHttpGetter(srv.URL)
+	if err := getter.Set(ctx, &pb.SetRequest{Group: "http-set-remove", Key: "Jack", Value: []byte("589")}); err != nil {
+		t.Fatalf("remote set failed: %v", err)
+	}
+
+	out := &pb.Response{}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-set-remove", Key: "Jack"}, out); err != nil {
+		t.Fatalf("remote get failed: %v", err)
+	}
+	if string(out.Value) != "589" || loads != 0 {
+		t.Fatalf("expected Jack=589 without loading, got %q (loads %d)", out.Value, loads)
+	}
+
+	if err := getter.Remove(ctx, &pb.Request{Group: "http-set-remove", Key: "Jack"}); err != nil {
+		t.Fatalf("remote remove failed: %v", err)
+	}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-set-remove", Key: "Jack"}, out); err != nil {
+		t.Fatalf("remote get failed: %v", err)
+	}
+	if string(out.Value) != "db-Jack" || loads != 1 {
+		t.Fatalf("expected Jack reloaded after remove, got %q (loads %d)", out.Value, loads)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gee := NewGroup("metrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.Set("http://localhost:8002")
+	pool.view.Load().getters["http://localhost:8002"].latency.observe(30 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	pool.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE geecache_gets_total counter",
+		`geecache_gets_total{group="metrics"} 2`,
+		`geecache_cache_hits_total{group="metrics"} 1`,
+		`geecache_loads_deduped_total{group="metrics"} 1`,
+		`geecache_cache_bytes{group="metrics",cache="main"} 6`,
+		`geecache_cache_items{group="metrics",cache="hot"} 0`,
+		"# TYPE geecache_peer_request_duration_seconds histogram",
+		`geecache_peer_request_duration_seconds_bucket{peer="http://localhost:8002",le="0.025"} 0`,
+		`geecache_peer_request_duration_seconds_bucket{peer="http://localhost:8002",le="0.05"} 1`,
+		`geecache_peer_request_duration_seconds_count{peer="http://localhost:8002"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
+
+func TestHTTPPoolMembership(t *testing.T) {
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	kept := pool.view.Load().getters["http://localhost:8002"]
+
+	// 重复设置相同的节点不会产生重复的虚拟节点，且沿用原来的httpGetter
+	pool.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	view := pool.view.Load()
+	if n := len(view.placement.Nodes()); n != 3 {
+		t.Fatalf("expected 3 peers, got %d", n)
+	}
+	if view.getters["http://localhost:8002"] != kept {
+		t.Fatalf("getter of an unchanged peer should be kept")
+	}
+
+	// 在更新节点的同时并发地选择节点
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			pool.PickPeer(strconv.Itoa(i))
+		}
+	}()
+	pool.Remove("http://localhost:8003")
+	<-done
+
+	if len(pool.GetAll()) != 1 {
+		t.Fatalf("expected 1 remote peer after remove, got %d", len(pool.GetAll()))
+	}
+	for i := 0; i < 1000; i++ {
+		if peer, ok := pool.PickPeer(strconv.Itoa(i)); ok && peer != pool.view.Load().peerGetters["http://localhost:8002"] {
+			t.Fatalf("key %d picked removed peer", i)
+		}
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.SetPeers(Peer{Addr: "http://localhost:8002", Weight: 2}, Peer{Addr: "http://localhost:8003"})
+	kept := pool.view.Load().getters["http://localhost:8002"]
+
+	pool.SetPlacement(func() consistenthash.Placement { return consistenthash.NewMaglev(0) })
+	view := pool.view.Load()
+	if _, ok := view.placement.(*consistenthash.Maglev); !ok {
+		t.Fatalf("expected maglev placement, got %T", view.placement)
+	}
+	if view.placement.Weight("http://localhost:8002") != 2 || view.getters["http://localhost:8002"] != kept {
+		t.Fatalf("switching placement should keep the peers")
+	}
+
+	// 之后的节点变化继续使用maglev
+	pool.Set("http://localhost:8002")
+	if _, ok := pool.view.Load().placement.(*consistenthash.Maglev); !ok {
+		t.Fatalf("membership change lost the placement")
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := pool.PickPeer(strconv.Itoa(i)); !ok {
+			t.Fatalf("key %d should be owned by the only peer", i)
+		}
+	}
+}
+
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	NewGroup("bounded", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	started, release := make(chan struct{}), make(chan struct{})
+	var remote *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		remote.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	remote = NewHTTPPool(srv.URL)
+
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.SetPlacement(func() consistenthash.Placement {
+		m := consistenthash.New(defaultReplicas, nil)
+		m.SetBoundedLoad(0.25)
+		return m
+	})
+	pool.Set(srv.URL)
+	ring := pool.view.Load().placement.(*consistenthash.Map)
+
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("expected Tom to be owned by the remote peer")
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.Get(context.Background(), &pb.Request{Group: "bounded", Key: "Tom"}, &pb.Response{})
+	}()
+
+	<-started
+	if load := ring.Loads()[srv.URL]; load != 1 {
+		t.Fatalf("expected 1 request in flight, got %d", load)
+	}
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if load := ring.Loads()[srv.URL]; load != 0 {
+		t.Fatalf("expected no request in flight, got %d", load)
+	}
+}
+
+// 主节点满载时，开启备份节点或对冲的group也把请求交给下一个节点，并标记为转发来的请求，
+// 收到请求的节点自己加载，不会再转发回满载的主节点
+func TestHTTPPoolBoundedLoadRouting(t *testing.T) {
+	var hits, forwarded [2]atomic.Int32
+	var srvs [2]*httptest.Server
+	for i := range srvs {
+		srvs[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			if r.Header.Get(forwardedHeader) != "" {
+				forwarded[i].Add(1)
+			}
+			body, _ := proto.Marshal(&pb.Response{Value: []byte(strconv.Itoa(i))})
+			w.Write(body)
+		}))
+		defer srvs[i].Close()
+	}
+	// 本机不在环上，所有key都归远端节点
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.SetPlacement(func() consistenthash.Placement {
+		m := consistenthash.New(defaultReplicas, nil)
+		m.SetBoundedLoad(0.25)
+		return m
+	})
+	pool.Set(srvs[0].URL, srvs[1].URL)
+	ring := pool.view.Load().placement.(*consistenthash.Map)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); ring.Get(k) == srvs[0].URL {
+			key = k
+		}
+	}
+	// 两个请求正在由主节点处理，再来一个就超过 (1+0.25) 倍的平均负载
+	ring.Inc(srvs[0].URL)
+	ring.Inc(srvs[0].URL)
+	defer ring.Done(srvs[0].URL)
+	defer ring.Done(srvs[0].URL)
+
+	backup := pool.view.Load().peerGetters[srvs[1].URL]
+	if peer, ok := pool.PickPeer(key); !ok || peer != backup {
+		t.Fatalf("expected the saturated owner of %s to be skipped", key)
+	}
+	if peers := pool.PickPeers(key, 2); len(peers) != 2 || peers[0] != backup {
+		t.Fatalf("expected PickPeers to start with the unsaturated node, got %v", peers)
+	}
+
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("key %s is owned by a peer", key)
+	})
+	groups := []*Group{
+		NewGroup("bounded-routing", 2<<10, getter),
+		NewGroup("bounded-routing-backup", 2<<10, getter, WithBackupPeers(1)),
+		NewGroup("bounded-routing-hedge", 2<<10, getter, WithHedging(HedgeOptions{MinDelay: time.Second, MaxDelay: time.Second})),
+	}
+	for i, gee := range groups {
+		gee.Register(pool)
+		if v, err := gee.Get(key); err != nil || v.String() != "1" {
+			t.Fatalf("%s: expected %s from the unsaturated node, got %q (err %v)", gee.name, key, v.String(), err)
+		}
+		if hits[0].Load() != 0 || hits[1].Load() != int32(i+1) || forwarded[1].Load() != int32(i+1) {
+			t.Fatalf("%s: expected one forwarded request to the unsaturated node only, hits %d/%d forwarded %d",
+				gee.name, hits[0].Load(), hits[1].Load(), forwarded[1].Load())
+		}
+	}
+	if load := ring.Loads()[srvs[1].URL]; load != 0 {
+		t.Fatalf("expected no request in flight on %s, got %d", srvs[1].URL, load)
+	}
+}
+
+// 主节点满载时读取交给其他节点，Set和Remove仍然发给环上的归属节点
+func TestHTTPPoolBoundedLoadSet(t *testing.T) {
+	var writes [2]atomic.Int32
+	var srvs [2]*httptest.Server
+	for i := range srvs {
+		srvs[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+				writes[i].Add(1)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srvs[i].Close()
+	}
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.SetPlacement(func() consistenthash.Placement {
+		m := consistenthash.New(defaultReplicas, nil)
+		m.SetBoundedLoad(0.25)
+		return m
+	})
+	pool.Set(srvs[0].URL, srvs[1].URL)
+	ring := pool.view.Load().placement.(*consistenthash.Map)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); ring.Get(k) == srvs[0].URL {
+			key = k
+		}
+	}
+	ring.Inc(srvs[0].URL)
+	ring.Inc(srvs[0].URL)
+	defer ring.Done(srvs[0].URL)
+	defer ring.Done(srvs[0].URL)
+
+	owner := pool.view.Load().peerGetters[srvs[0].URL]
+	if peer, ok := pool.PickPeer(key); !ok || peer == owner {
+		t.Fatalf("expected reads of %s to skip its saturated owner", key)
+	}
+	if peer, ok := pool.PickOwner(key); !ok || peer != owner {
+		t.Fatalf("expected PickOwner to ignore the loads")
+	}
+
+	gee := NewGroup("bounded-set", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	gee.Register(pool)
+	if err := gee.Set(key, []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gee.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if writes[0].Load() != 2 || writes[1].Load() != 0 {
+		t.Fatalf("expected Set and Remove on the saturated owner, writes %d/%d", writes[0].Load(), writes[1].Load())
+	}
+}
+
+func TestHTTPPoolPickPeers(t *testing.T) {
+	pool := NewHTTPPool("http://localhost:8003")
+	pool.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	view := pool.view.Load()
+	ring := view.placement.(*consistenthash.Map)
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owners := ring.GetN(key, 3)
+		peers := pool.PickPeers(key, 3)
+		// 返回的节点在遇到本机时截止
+		expect := 0
+		for expect < len(owners) && owners[expect] != "http://localhost:8003" {
+			expect++
+		}
+		if len(peers) != expect {
+			t.Fatalf("key %s: owners %v, expected %d peers, got %d", key, owners, expect, len(peers))
+		}
+		for j, peer := range peers {
+			if peer != view.peerGetters[owners[j]] {
+				t.Fatalf("key %s: peer %d is not %s", key, j, owners[j])
+			}
+		}
+	}
+}
+
+func TestHTTPPush(t *testing.T) {
+	loads := 0
+	gee := NewGroup("http-push", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}))
+
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	ctx := context.Background()
+	getter := newHttpGetter(srv.URL)
+	if err := getter.Push(ctx, &pb.SetRequest{Group: "http-push", Key: "Sam", Value: []byte("567")}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if v, err := gee.Get("Sam"); err != nil || v.String() != "567" || loads != 0 {
+		t.Fatalf("expected the pushed replica Sam=567, got %q (loads %d, err %v)", v.String(), loads, err)
+	}
+	if s := gee.Stats(); s.ReplicasReceived.Get() != 1 {
+		t.Fatalf("expected 1 replica received, got %d", s.ReplicasReceived.Get())
+	}
+
+	// push 接口只接受POST
+	res, err := http.Get(srv.URL + defaultBasePath + pushPrefix + "http-push/Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET on the push endpoint, got %d", res.StatusCode)
+	}
+}
+
+func TestHTTPPoolPickReplicas(t *testing.T) {
+	pool := NewHTTPPool("http://localhost:8002")
+	pool.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	view := pool.view.Load()
+	ring := view.placement.(*consistenthash.Map)
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		var expect []PeerGetter
+		for _, owner := range ring.GetN(key, 2) {
+			if owner != "http://localhost:8002" {
+				expect = append(expect, view.peerGetters[owner])
+			}
+		}
+		if got := pool.PickReplicas(key, 2); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("key %s: expected %d replicas, got %d", key, len(expect), len(got))
+		}
+	}
+}
+
+func TestHTTPPoolHealthCheck(t *testing.T) {
+	var failing atomic.Bool
+	var flakyPool *HTTPPool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		flakyPool.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	flakyPool = NewHTTPPool(flaky.URL)
+
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.Set("http://localhost:8001", flaky.URL)
+
+	changes := make(chan PeerState, 4)
+	pool.StartHealthCheck(HealthCheckOptions{
+		Interval:         5 * time.Millisecond,
+		FailureThreshold: 2,
+		OnStateChange: func(peer string, state PeerState) {
+			if peer == flaky.URL {
+				changes <- state
+			}
+		},
+	})
+	defer pool.StopHealthCheck()
+
+	onRing := func() bool {
+		for _, node := range pool.view.Load().placement.Nodes() {
+			if node == flaky.URL {
+				return true
+			}
+		}
+		return false
+	}
+	waitState := func(want PeerState) {
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Fatalf("expected peer %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("peer was not marked %s", want)
+		}
+	}
+
+	failing.Store(true)
+	waitState(PeerDown)
+	if onRing() || pool.PeerStates()[flaky.URL] != PeerDown {
+		t.Fatalf("down peer should be taken out of the ring")
+	}
+	if _, ok := pool.PickPeer("Tom"); ok {
+		t.Fatalf("keys of the down peer should be served locally")
+	}
+
+	failing.Store(false)
+	waitState(PeerUp)
+	if !onRing() || pool.PeerStates()[flaky.URL] != PeerUp {
+		t.Fatalf("recovered peer should be back on the ring")
+	}
+}
+
+// roundTripperFunc 用于在测试中观察pool发出的请求
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestHTTPPoolOptionsClient(t *testing.T) {
+	NewGroup("http-client", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	var server *HTTPPool
+	var protoMajor atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protoMajor.Store(int32(r.ProtoMajor))
+		server.ServeHTTP(w, r)
+	})
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	server = NewHTTPPool(srv.URL)
+
+	ctx := context.Background()
+	out := &pb.Response{}
+
+	// 自定义的transport会收到所有请求
+	var requests atomic.Int32
+	pool := NewHTTPPoolOpts("http://localhost:8001", &HTTPPoolOptions{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			requests.Add(1)
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	})
+	pool.Set(srv.URL)
+	peer, _ := pool.PickPeer("Tom")
+	if err := peer.Get(ctx, &pb.Request{Group: "http-client", Key: "Tom"}, out); err != nil || string(out.Value) != "db-Tom" {
+		t.Fatalf("expected Tom=db-Tom, got %q (err %v)", out.Value, err)
+	}
+	if requests.Load() != 1 || protoMajor.Load() != 1 {
+		t.Fatalf("expected 1 HTTP/1 request through the transport, got %d (HTTP/%d)", requests.Load(), protoMajor.Load())
+	}
+
+	// HTTP2 对http节点使用h2c
+	pool = NewHTTPPoolOpts("http://localhost:8001", &HTTPPoolOptions{HTTP2: true})
+	pool.Set(srv.URL)
+	peer, _ = pool.PickPeer("Tom")
+	if err := peer.Get(ctx, &pb.Request{Group: "http-client", Key: "Tom"}, out); err != nil || string(out.Value) != "db-Tom" {
+		t.Fatalf("expected Tom=db-Tom over HTTP/2, got %q (err %v)", out.Value, err)
+	}
+	if protoMajor.Load() != 2 {
+		t.Fatalf("expected an HTTP/2 request, got HTTP/%d", protoMajor.Load())
+	}
+
+	// 卡住的节点在超时之后返回错误
+	wedged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer wedged.Close()
+	pool = NewHTTPPoolOpts("http://localhost:8001", &HTTPPoolOptions{Timeout: 20 * time.Millisecond})
+	pool.Set(wedged.URL)
+	peer, _ = pool.PickPeer("Tom")
+	start := time.Now()
+	if err := peer.Get(ctx, &pb.Request{Group: "http-client", Key: "Tom"}, out); err == nil {
+		t.Fatalf("expected a timeout from the wedged peer")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("request to the wedged peer took %v", d)
+	}
+}
+
+func TestHTTPPoolOptionsRing(t *testing.T) {
+	NewGroup("http-base-path", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	opts := &HTTPPoolOptions{BasePath: "cache", Replicas: 3}
+	var server *HTTPPool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cache/", func(w http.ResponseWriter, r *http.Request) {
+		server.ServeHTTP(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	server = NewHTTPPoolOpts(srv.URL, opts)
+
+	// 服务端和客户端都使用同样的BasePath
+	var hashes atomic.Int32
+	opts.HashFn = func(data []byte) uint32 {
+		hashes.Add(1)
+		return crc32.ChecksumIEEE(data)
+	}
+	pool := NewHTTPPoolOpts("http://localhost:8001", opts)
+	pool.Set(srv.URL, "http://localhost:8002")
+	if n := hashes.Load(); n != 2*3 {
+		t.Fatalf("expected 3 virtual nodes per peer hashed with HashFn, got %d hashes", n)
+	}
+
+	var key string
+	var peer PeerGetter
+	for i := 0; peer == nil; i++ {
+		key = strconv.Itoa(i)
+		if p, ok := pool.PickPeer(key); ok && p == pool.view.Load().peerGetters[srv.URL] {
+			peer = p
+		}
+	}
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "http-base-path", Key: key}, out); err != nil || string(out.Value) != "db-"+key {
+		t.Fatalf("expected %s=db-%s under /cache/, got %q (err %v)", key, key, out.Value, err)
+	}
+
+	// SetPlacement(nil) 回到按Replicas和HashFn生成的哈希环
+	pool.SetPlacement(func() consistenthash.Placement { return consistenthash.NewJump() })
+	hashes.Store(0)
+	pool.SetPlacement(nil)
+	if n := hashes.Load(); n != 2*3 {
+		t.Fatalf("expected the configured ring back, got %d hashes", n)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	NewGroup("serve", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		switch key {
+		case "broken":
+			return nil, fmt.Errorf("db is broken")
+		case "slow":
+			return nil, context.DeadlineExceeded
+		}
+		return []byte("db-" + key), nil
+	}))
+	pool := NewHTTPPoolOpts("http://localhost:8001", &HTTPPoolOptions{MaxBodyBytes: 64})
+
+	setBody := func(value string) io.Reader {
+		body, _ := proto.Marshal(&pb.SetRequest{Group: "serve", Key: "Tom", Value: []byte(value)})
+		return bytes.NewReader(body)
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   io.Reader
+		status int
+		allow  string
+	}{
+		{"outside base path", http.MethodGet, "/favicon.ico", nil, http.StatusNotFound, ""},
+		{"no group", http.MethodGet, "/_geecache/", nil, http.StatusBadRequest, ""},
+		{"no key", http.MethodGet, "/_geecache/serve", nil, http.StatusBadRequest, ""},
+		{"unknown group", http.MethodGet, "/_geecache/no-such-group/Tom", nil, http.StatusNotFound, ""},
+		{"get", http.MethodGet, "/_geecache/serve/Tom", nil, http.StatusOK, ""},
+		{"getter error", http.MethodGet, "/_geecache/serve/broken", nil, http.StatusInternalServerError, ""},
+		{"getter timeout", http.MethodGet, "/_geecache/serve/slow", nil, http.StatusGatewayTimeout, ""},
+		{"bad method", http.MethodPost, "/_geecache/serve/Tom", nil, http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{"set", http.MethodPut, "/_geecache/serve/Tom", setBody("630"), http.StatusNoContent, ""},
+		{"set bad body", http.MethodPut, "/_geecache/serve/Tom", strings.NewReader("\xff\xff"), http.StatusBadRequest, ""},
+		{"set too large", http.MethodPut, "/_geecache/serve/Tom", setBody(strings.Repeat("x", 100)), http.StatusRequestEntityTooLarge, ""},
+		{"delete", http.MethodDelete, "/_geecache/serve/Tom", nil, http.StatusNoContent, ""},
+		{"push", http.MethodPost, "/_geecache/_push/serve/Tom", setBody("630"), http.StatusNoContent, ""},
+		{"push bad method", http.MethodPut, "/_geecache/_push/serve/Tom", setBody("630"), http.StatusMethodNotAllowed, "POST"},
+		{"health", http.MethodGet, "/_geecache/_health", nil, http.StatusOK, ""},
+		{"health bad method", http.MethodDelete, "/_geecache/_health", nil, http.StatusMethodNotAllowed, "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			pool.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("%s %s: expected status %d, got %d (%s)", tt.method, tt.path, tt.status, rec.Code, rec.Body)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.allow {
+				t.Fatalf("%s %s: expected Allow %q, got %q", tt.method, tt.path, tt.allow, allow)
+			}
+		})
+	}
+}
+
+func TestHTTPGetMany(t *testing.T) {
+	NewGroup("http-get-many", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("key %s not exist", key)
+		}
+		return []byte("db-" + key), nil
+	}))
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	out := &pb.BatchResponse{}
+	in := &pb.BatchRequest{Group: "http-get-many", Keys: []string{"Tom", "Jack", "missing"}}
+	if err := newHttpGetter(srv.URL).GetMany(context.Background(), in, out); err != nil {
+		t.Fatalf("remote GetMany failed: %v", err)
+	}
+	if len(out.Values) != 2 || string(out.Values["Tom"]) != "db-Tom" || string(out.Values["Jack"]) != "db-Jack" {
+		t.Fatalf("unexpected values %v", out.Values)
+	}
+	if !strings.Contains(out.Errors["missing"], "not exist") {
+		t.Fatalf("expected the error of missing, got %v", out.Errors)
+	}
+
+	in.Group = "no-such-group"
+	if err := newHttpGetter(srv.URL).GetMany(context.Background(), in, out); err == nil {
+		t.Fatalf("expected error for unknown group")
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	NewGroup("http-not-found", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}))
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	res, err := http.Get(srv.URL + defaultBasePath + "http-not-found/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing key, got %d", res.StatusCode)
+	}
+
+	ctx := context.Background()
+	getter := newHttpGetter(srv.URL)
+	out := &pb.Response{}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-not-found", Key: "Tom"}, out); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from the peer, got %v", err)
+	}
+	// 找不到group同样是404，但不是key不存在
+	if err := getter.Get(ctx, &pb.Request{Group: "no-such-group", Key: "Tom"}, out); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a failure other than ErrNotFound for unknown group, got %v", err)
+	}
+
+	// 错误信息里碰巧含有 ErrNotFound 的文本也不是key不存在
+	NewGroup("http-not-found-text", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("upstream: %s", ErrNotFound.Error())
+	}))
+	if err := getter.Get(ctx, &pb.Request{Group: "http-not-found-text", Key: "Tom"}, out); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a failure other than ErrNotFound, got %v", err)
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := getter.GetMany(ctx, &pb.BatchRequest{Group: "http-not-found", Keys: []string{"Tom"}}, batch); err != nil {
+		t.Fatalf("remote GetMany failed: %v", err)
+	}
+	if len(batch.NotFound) != 1 || batch.NotFound[0] != "Tom" || len(batch.Errors) != 0 {
+		t.Fatalf("expected Tom listed as not found, got %v and errors %v", batch.NotFound, batch.Errors)
+	}
+}
+
+func TestHTTPForwarded(t *testing.T) {
+	loads := 0
+	gee := NewGroup("http-forwarded", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("db-" + key), nil
+	}))
+	owner := newFakePeer() // 服务端眼中所有key的归属节点
+	gee.Register(fakePicker{peer: owner})
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	ctx := context.Background()
+	getter := newHttpGetter(srv.URL)
+	out := &pb.Response{}
+	// 没有标记的请求仍由服务端转发给归属节点
+	if err := getter.Get(ctx, &pb.Request{Group: "http-forwarded", Key: "Tom"}, out); err != nil || string(out.Value) != "peer-Tom" {
+		t.Fatalf("expected Tom from the owner, got %q (err %v)", out.Value, err)
+	}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-forwarded", Key: "Jack", Forwarded: true}, out); err != nil || string(out.Value) != "db-Jack" {
+		t.Fatalf("expected forwarded Jack loaded by the server, got %q (err %v)", out.Value, err)
+	}
+	batch := &pb.BatchResponse{}
+	if err := getter.GetMany(ctx, &pb.BatchRequest{Group: "http-forwarded", Keys: []string{"Sam"}, Forwarded: true}, batch); err != nil {
+		t.Fatalf("remote GetMany failed: %v", err)
+	}
+	if string(batch.Values["Sam"]) != "db-Sam" {
+		t.Fatalf("expected forwarded Sam loaded by the server, got %v", batch.Values)
+	}
+	if owner.gets != 1 || len(owner.batches) != 0 || loads != 2 {
+		t.Fatalf("forwarded requests should not reach the owner, gets %d batches %d loads %d", owner.gets, len(owner.batches), loads)
+	}
+}
+
+func TestHTTPSetKeyOnly(t *testing.T) {
+	NewGroup("http-key-only", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}), WithBloomFilter(BloomFilterOptions{
+		Seed: func(add func(string)) error { return nil },
+	}))
+	var pool *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	pool = NewHTTPPool(srv.URL)
+
+	ctx := context.Background()
+	getter := newHttpGetter(srv.URL)
+	out := &pb.Response{}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-key-only", Key: "Tom"}, out); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected Tom rejected by the empty filter, got %v", err)
+	}
+	// 只通知key的存在，服务端不缓存值，之后的Get照常加载
+	if err := getter.Set(ctx, &pb.SetRequest{Group: "http-key-only", Key: "Tom", KeyOnly: true}); err != nil {
+		t.Fatalf("key-only set failed: %v", err)
+	}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-key-only", Key: "Tom"}, out); err != nil || string(out.Value) != "db-Tom" {
+		t.Fatalf("expected Tom loaded after key-only set, got %q (err %v)", out.Value, err)
+	}
+}
@@ -3,6 +3,7 @@ package lru
 import (
 	"container/list"
 	"log"
+	"time"
 )
 
 // Cache is a LRU cache, It is not safe for concurrent access
@@ -14,13 +15,57 @@ type Cache struct {
 	// optional and executed when an entry is purged
 	// 某条记录被移除时的回调函数，可以是nil
 	// 因为插入的时候出现了removeOldest，所以使用者可能希望移除的是什么，再对应地去操作
-	OnEvicted func(key string, value Value)
+	// reason 说明了记录是因为容量不足还是过期被移除的
+	OnEvicted func(key string, value Value, reason EvictReason)
+
+	now func() time.Time // 获取当前时间，方便测试替换
+}
+
+// EvictReason tells OnEvicted why an entry was removed
+type EvictReason int
+
+const (
+	// EvictedCapacity means the entry was the oldest one when the cache was full
+	EvictedCapacity EvictReason = iota
+	// EvictedExpired means the entry's TTL, and its stale period if any,
+	// has passed
+	EvictedExpired
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictedCapacity:
+		return "capacity"
+	case EvictedExpired:
+		return "expired"
+	}
+	return "unknown"
 }
 
 // entry is the data's type which  is stored in cache
 type entry struct {
-	key   string // 在双链表的元素也存储key是为了方便在map上做删除
-	value Value
+	key    string // 在双链表的元素也存储key是为了方便在map上做删除
+	value  Value
+	added  time.Time // 写入的时间
+	expire time.Time // 过期时间，零值表示永不过期
+	// 过期之后还可以通过 GetItem 读到的截止时间，之后才真正删除
+	staleUntil time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+// 过了stale的期限，可以删除了
+func (e *entry) dead(now time.Time) bool {
+	return !e.staleUntil.IsZero() && !now.Before(e.staleUntil)
+}
+
+// An Item is an entry returned by GetItem together with its times
+type Item struct {
+	Value  Value
+	Added  time.Time // when the entry was added
+	Expire time.Time // when the entry expires, zero if it never does
 }
 
 // Value use len to count how many bytes it takes
@@ -29,7 +74,7 @@ type Value interface {
 }
 
 // New is the Constructor of Cache
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, onEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes: maxBytes,
 		nbytes:   0,
@@ -37,6 +82,7 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 
 		cache:     make(map[string]*list.Element),
 		OnEvicted: onEvicted,
+		now:       time.Now,
 	}
 }
 
@@ -44,26 +90,71 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 func (c *Cache) RemoveOldest() {
 	elem := c.ll.Back()
 	if elem != nil {
-		c.ll.Remove(elem)
-		kv := elem.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(elem, EvictedCapacity)
+	}
+}
+
+// RemoveExpired removes all the items whose TTL and stale period have
+// passed and returns how many were removed
+// 由使用者定期调用（例如后台的清理协程），Get 只会惰性地删除访问到的过期记录
+func (c *Cache) RemoveExpired() int {
+	now := c.now()
+	removed := 0
+	for elem := c.ll.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(*entry).dead(now) {
+			c.removeElement(elem, EvictedExpired)
+			removed++
 		}
+		elem = prev
+	}
+	return removed
+}
+
+func (c *Cache) removeElement(elem *list.Element, reason EvictReason) {
+	c.ll.Remove(elem)
+	kv := elem.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
 	}
 }
 
 // Get look ups a key's value
+// 过期的记录当作未命中处理，过了stale期限的记录会在这里被删除
 func (c *Cache) Get(key string) (Value, bool) {
 	if elem, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(elem)
 		val := elem.Value.(*entry)
+		now := c.now()
+		if val.dead(now) {
+			c.removeElement(elem, EvictedExpired)
+			return nil, false
+		}
+		if val.expired(now) {
+			return nil, false
+		}
+		c.ll.MoveToFront(elem)
 		return val.value, true
 	}
 	return nil, false
 }
 
+// GetItem is like Get but also returns entries which expired less than
+// their stale period ago, the caller tells them apart by Item.Expire
+func (c *Cache) GetItem(key string) (Item, bool) {
+	if elem, ok := c.cache[key]; ok {
+		val := elem.Value.(*entry)
+		if val.dead(c.now()) {
+			c.removeElement(elem, EvictedExpired)
+			return Item{}, false
+		}
+		c.ll.MoveToFront(elem)
+		return Item{Value: val.value, Added: val.added, Expire: val.expire}, true
+	}
+	return Item{}, false
+}
+
 func (c *Cache) onOversized(sz int64) {
 	log.Printf("Add failed: kv is too large. maxBytes: %d but sizeof key+val: %d",
 		c.maxBytes, sz)
@@ -72,6 +163,28 @@ func (c *Cache) onOversized(sz int64) {
 // Add can insert a new key value into cache
 // if the key already exists then cover the existing value
 func (c *Cache) Add(key string, val Value) Value {
+	return c.AddWithTTL(key, val, 0)
+}
+
+// AddWithTTL is like Add but the entry expires after ttl,
+// a ttl <= 0 means the entry never expires
+func (c *Cache) AddWithTTL(key string, val Value, ttl time.Duration) Value {
+	return c.AddWithStale(key, val, ttl, 0)
+}
+
+// AddWithStale is like AddWithTTL but keeps the expired entry for another
+// stale duration, during which only GetItem returns it
+func (c *Cache) AddWithStale(key string, val Value, ttl, stale time.Duration) Value {
+	now := c.now()
+	var expire, staleUntil time.Time
+	if ttl > 0 {
+		expire = now.Add(ttl)
+		staleUntil = expire
+		if stale > 0 {
+			staleUntil = expire.Add(stale)
+		}
+	}
+
 	var nEntrySize int64
 	if int64(len(key))+int64(val.Len()) > c.maxBytes {
 		c.onOversized(int64(len(key)) + int64(val.Len()))
@@ -89,6 +202,9 @@ func (c *Cache) Add(key string, val Value) Value {
 		}
 
 		kv.value = val
+		kv.added = now
+		kv.expire = expire
+		kv.staleUntil = staleUntil
 		c.ll.MoveToFront(elem)
 		c.nbytes += nEntrySize
 		return val
@@ -99,7 +215,7 @@ func (c *Cache) Add(key string, val Value) Value {
 		}
 
 		// insert the new entry and update the size
-		ele := c.ll.PushFront(&entry{key, val})
+		ele := c.ll.PushFront(&entry{key, val, now, expire, staleUntil})
 		c.cache[key] = ele
 		c.nbytes += nEntrySize
 	}
@@ -125,3 +241,8 @@ func (c *Cache) Del(key string) Value {
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// Bytes returns how many bytes the keys and values take up
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
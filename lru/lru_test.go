@@ -5,6 +5,7 @@ import (
 	"log"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -60,7 +61,7 @@ func TestRemoveoldest(t *testing.T) {
 	v1, v2, v3 := "value1", "value2", "value3"
 	sz := len(k1 + k2 + v1 + v2)
 
-	lru := New(int64(sz), func(s string, v Value) {
+	lru := New(int64(sz), func(s string, v Value, _ EvictReason) {
 		fmt.Println("remove oldest ", s, string(v.(String)))
 	})
 	lru.Add(k1, String(v1))
@@ -88,7 +89,7 @@ func TestRemoveoldest(t *testing.T) {
 
 func TestOnEvicted(t *testing.T) {
 	removedKeys := make([]string, 0)
-	callback := func(key string, value Value) {
+	callback := func(key string, value Value, _ EvictReason) {
 		removedKeys = append(removedKeys, key)
 	}
 	lru := New(int64(10), callback)
@@ -102,3 +103,83 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("expected onEvicted failed, expect keys %v", expect)
 	}
 }
+
+func TestTTL(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(100000), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.now = func() time.Time { return now }
+
+	lru.AddWithTTL("key1", String("v1"), time.Second)
+	lru.AddWithTTL("key2", String("v2"), 3*time.Second)
+	lru.Add("key3", String("v3"))
+
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("key1 expired too early")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if reasons["key1"] != EvictedExpired {
+		t.Fatalf("key1 evicted with reason %v, expected %v", reasons["key1"], EvictedExpired)
+	}
+	if lru.nbytes != int64(len("key2v2key3v3")) {
+		t.Fatalf("expired entry's bytes not released, leave %d bytes", lru.nbytes)
+	}
+
+	now = now.Add(2 * time.Second)
+	if n := lru.RemoveExpired(); n != 1 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired removed %d, leave %d entries", n, lru.Len())
+	}
+	if _, ok := lru.Get("key3"); !ok {
+		t.Fatalf("key3 without ttl should never expire")
+	}
+}
+
+func TestEvictReason(t *testing.T) {
+	var got []EvictReason
+	lru := New(int64(8), func(key string, value Value, reason EvictReason) {
+		got = append(got, reason)
+	})
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	lru.Add("k3", String("v3"))
+
+	expect := []EvictReason{EvictedCapacity}
+	if !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expected reasons %v, got %v", expect, got)
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	lru := New(int64(100000), nil)
+	lru.now = func() time.Time { return now }
+
+	lru.AddWithStale("key1", String("v1"), time.Second, 2*time.Second)
+	if item, ok := lru.GetItem("key1"); !ok || item.Value.(String) != "v1" || !item.Expire.Equal(now.Add(time.Second)) {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	// 过期之后只有GetItem能读到
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if item, ok := lru.GetItem("key1"); !ok || item.Value.(String) != "v1" {
+		t.Fatalf("expected stale key1, got %+v", item)
+	}
+	if n := lru.RemoveExpired(); n != 0 {
+		t.Fatalf("stale entry removed too early")
+	}
+
+	// 过了stale期限之后删除
+	now = now.Add(time.Second)
+	if _, ok := lru.GetItem("key1"); ok || lru.Len() != 0 {
+		t.Fatalf("key1 should be removed after its stale period")
+	}
+}
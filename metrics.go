@@ -0,0 +1,166 @@
+package geecache
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求耗时的分桶上界，单位为秒
+var defaultLatencyBuckets = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// histogram 是一个简单的累积直方图，用于记录向远端节点请求的耗时
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // 每个桶的上界，升序
+	counts  []uint64  // counts[i] 为落在 (buckets[i-1], buckets[i]] 的次数，最后一个为 +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// 以 Prometheus 文本格式写出直方图，labels 为除 le 以外的标签
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 按照文本格式的要求转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// MetricsHandler returns an http.Handler which exports the statistics of
+// every group and the latency of the requests to each peer in the
+// Prometheus text exposition format. It is meant to be mounted next to
+// the pool, e.g. mux.Handle("/metrics", pool.MetricsHandler())
+func (p *HTTPPool) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p.writeMetrics(w)
+	})
+}
+
+func (p *HTTPPool) writeMetrics(w io.Writer) {
+	gs := allGroups()
+
+	counters := []struct {
+		name, help string
+		get        func(*Stats) int64
+	}{
+		{"geecache_gets_total", "Get requests, including the ones from peers.", func(s *Stats) int64 { return s.Gets.Get() }},
+		{"geecache_cache_hits_total", "Get requests served from the main or hot cache.", func(s *Stats) int64 { return s.CacheHits.Get() }},
+		{"geecache_negative_hits_total", "Get requests answered with not-found from the negative cache.", func(s *Stats) int64 { return s.NegativeHits.Get() }},
+		{"geecache_bloom_rejects_total", "Get requests rejected by the Bloom filter of existing keys.", func(s *Stats) int64 { return s.BloomRejects.Get() }},
+		{"geecache_bloom_false_positives_total", "Gets which passed the Bloom filter of this node but found the key does not exist.", func(s *Stats) int64 { return s.BloomFalsePositives.Get() }},
+		{"geecache_stale_hits_total", "Get requests served an expired value while it is refreshed.", func(s *Stats) int64 { return s.StaleHits.Get() }},
+		{"geecache_loads_total", "Cache misses which had to be loaded.", func(s *Stats) int64 { return s.Loads.Get() }},
+		{"geecache_loads_deduped_total", "Loads actually run after singleflight deduplication.", func(s *Stats) int64 { return s.LoadsDeduped.Get() }},
+		{"geecache_peer_loads_total", "Values loaded from remote peers.", func(s *Stats) int64 { return s.PeerLoads.Get() }},
+		{"geecache_peer_errors_total", "Failed requests to remote peers.", func(s *Stats) int64 { return s.PeerErrors.Get() }},
+		{"geecache_peer_retries_total", "Requests to remote peers retried after a failure.", func(s *Stats) int64 { return s.PeerRetries.Get() }},
+		{"geecache_peer_circuit_open_total", "Requests to remote peers skipped because the circuit breaker was open.", func(s *Stats) int64 { return s.PeerCircuitOpen.Get() }},
+		{"geecache_hedged_requests_total", "Second requests sent because the owner of the key was slow.", func(s *Stats) int64 { return s.HedgedRequests.Get() }},
+		{"geecache_hedge_wins_total", "Hedged requests which answered before the owner.", func(s *Stats) int64 { return s.HedgeWins.Get() }},
+		{"geecache_local_loads_total", "Values loaded by the local getter.", func(s *Stats) int64 { return s.LocalLoads.Get() }},
+		{"geecache_local_load_errors_total", "Failed loads of the local getter.", func(s *Stats) int64 { return s.LocalLoadErrs.Get() }},
+		{"geecache_batch_loads_total", "GetMany calls of a BatchGetter.", func(s *Stats) int64 { return s.BatchLoads.Get() }},
+		{"geecache_not_found_total", "Loads which found the key does not exist.", func(s *Stats) int64 { return s.NotFound.Get() }},
+		{"geecache_refreshes_total", "Background reloads of stale or aging entries.", func(s *Stats) int64 { return s.Refreshes.Get() }},
+		{"geecache_refresh_errors_total", "Failed background reloads.", func(s *Stats) int64 { return s.RefreshErrs.Get() }},
+		{"geecache_server_requests_total", "Get requests which came over the network from peers.", func(s *Stats) int64 { return s.ServerRequests.Get() }},
+		{"geecache_replica_pushes_total", "Values pushed to the other owners of a key.", func(s *Stats) int64 { return s.ReplicaPushes.Get() }},
+		{"geecache_replica_push_errors_total", "Failed pushes to the other owners of a key.", func(s *Stats) int64 { return s.ReplicaPushErrs.Get() }},
+		{"geecache_replicas_received_total", "Values pushed here by another owner of the key.", func(s *Stats) int64 { return s.ReplicasReceived.Get() }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, g := range gs {
+			fmt.Fprintf(w, "%s{%s} %d\n", c.name, label("group", g.name), c.get(&g.stats))
+		}
+	}
+
+	caches := []struct {
+		name, typ, help string
+		get             func(CacheStats) int64
+	}{
+		{"geecache_cache_bytes", "gauge", "Bytes taken by the keys and values in the cache.", func(s CacheStats) int64 { return s.Bytes }},
+		{"geecache_cache_items", "gauge", "Entries in the cache.", func(s CacheStats) int64 { return s.Items }},
+		{"geecache_cache_gets_total", "counter", "Lookups in the cache.", func(s CacheStats) int64 { return s.Gets }},
+		{"geecache_cache_get_hits_total", "counter", "Lookups which found the key in the cache.", func(s CacheStats) int64 { return s.Hits }},
+		{"geecache_cache_evictions_total", "counter", "Entries removed because the cache was full or they expired.", func(s CacheStats) int64 { return s.Evictions }},
+	}
+	types := []struct {
+		which CacheType
+		name  string
+	}{{MainCache, "main"}, {HotCache, "hot"}}
+	for _, c := range caches {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.typ)
+		for _, g := range gs {
+			for _, t := range types {
+				fmt.Fprintf(w, "%s{%s,%s} %d\n", c.name, label("group", g.name), label("cache", t.name), c.get(g.CacheStats(t.which)))
+			}
+		}
+	}
+
+	view := p.view.Load()
+	peers := make([]string, 0, len(view.getters))
+	for peer := range view.getters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	getters := make([]*httpGetter, len(peers))
+	for i, peer := range peers {
+		getters[i] = view.getters[peer]
+	}
+
+	const latency = "geecache_peer_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of Get requests sent to remote peers.\n# TYPE %s histogram\n", latency, latency)
+	for i, hg := range getters {
+		hg.latency.write(w, latency, label("peer", peers[i]))
+	}
+}
@@ -0,0 +1,158 @@
+package geecache
+
+import "time"
+
+// A GroupOption configures optional behaviours of a Group
+type GroupOption func(*Group)
+
+// WithTTL sets the default time-to-live of the entries the Group caches,
+// ttl <= 0 means entries never expire
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithNegativeTTL caches for ttl the keys which the getter or the owning
+// peer reported as ErrNotFound, so that repeated requests for a missing
+// key do not reach the data source. ttl <= 0 disables negative caching
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithBloomFilter keeps a Bloom filter of the keys which exist, seeded
+// by opts.Seed and updated by Set and by loads. Gets of keys this node
+// owns which the filter has never seen fail with ErrNotFound before the
+// getter is called. Keys owned by another peer are checked by the owner,
+// which answers not-found over the network. Since the owner of a key
+// changes with the peers, Set tells every peer about the new key; a peer
+// which was unreachable then misses it and Set returns an error. Keys
+// created in the data source without Set must be added on every node
+// with AddToBloomFilter. It panics without Seed, since an empty filter
+// would reject every key
+func WithBloomFilter(opts BloomFilterOptions) GroupOption {
+	if opts.Seed == nil {
+		panic("bloom filter needs a Seed")
+	}
+	return func(g *Group) {
+		g.bloomOpts = &opts
+	}
+}
+
+// WithRefreshAhead reloads an entry in the background when it is read
+// after fraction (0 < fraction < 1) of its TTL has passed, so that hot
+// keys are refreshed before they expire. It needs WithTTL
+func WithRefreshAhead(fraction float64) GroupOption {
+	return func(g *Group) {
+		if fraction <= 0 || fraction >= 1 {
+			panic("refresh-ahead fraction must be in (0, 1)")
+		}
+		g.refreshAhead = fraction
+	}
+}
+
+// WithStaleWhileRevalidate keeps entries for maxStale after they expire.
+// A Get of such an entry returns the stale value at once while one
+// background load refreshes it, values older than their TTL plus
+// maxStale are never served. Requests forwarded by peers only get
+// unexpired values, since the peer caches them anew. It needs WithTTL
+func WithStaleWhileRevalidate(maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.maxStale = maxStale
+	}
+}
+
+// WithJanitor starts a background goroutine which removes expired
+// entries every interval, instead of only dropping them lazily on Get.
+// Group.Close stops it
+func WithJanitor(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.janitorInterval = interval
+	}
+}
+
+// WithHotCache enables a hotCache which takes share (0 < share < 1) of the
+// Group's cacheBytes and keeps some of the values fetched from remote peers,
+// so that hot keys owned by another node are served locally. Set and Remove
+// then also drop key from every peer, as Invalidate does, so all the nodes
+// should enable it together
+func WithHotCache(share float64) GroupOption {
+	return func(g *Group) {
+		if share < 0 || share >= 1 {
+			panic("hotCache share must be in [0, 1)")
+		}
+		g.hotCacheShare = share
+	}
+}
+
+// WithHotCacheOneIn sets the probability 1/n that a value fetched from a
+// remote peer is stored in the hotCache, n <= 1 stores every value
+func WithHotCacheOneIn(n int) GroupOption {
+	return func(g *Group) {
+		g.hotCacheOneIn = n
+	}
+}
+
+// WithBackupPeers makes the Group try up to n backup owners of a key, in
+// ring order, when the primary owner fails, before loading it locally.
+// It needs a PeerPicker which implements ReplicaPicker
+func WithBackupPeers(n int) GroupOption {
+	return func(g *Group) {
+		g.backupPeers = n
+	}
+}
+
+// WithRetry retries a failed request to a peer with jittered exponential
+// backoff before the Group moves on to the next owner or loads locally
+func WithRetry(policy RetryPolicy) GroupOption {
+	return func(g *Group) {
+		g.retry = policy
+	}
+}
+
+// WithCircuitBreaker gives every peer a circuit breaker: after
+// FailureThreshold failed requests in a row the peer is skipped for
+// OpenTimeout, then a single trial request decides whether it is used again
+func WithCircuitBreaker(opts CircuitBreakerOptions) GroupOption {
+	return func(g *Group) {
+		g.breakerOpts = &opts
+	}
+}
+
+// WithHedging sends a second request when the owner of a key has not
+// answered within a percentile of the recent peer latencies: to the next
+// owner of the key if the PeerPicker implements ReplicaPicker, to the
+// local getter otherwise. The first success wins and the other request
+// is cancelled. When the owner fails before the hedge is sent, only the
+// backups of WithBackupPeers are tried before the local getter
+func WithHedging(opts HedgeOptions) GroupOption {
+	return func(g *Group) {
+		g.hedge = newHedger(opts)
+	}
+}
+
+// WithBatchWindow tunes how a getter which implements BatchGetter is used
+// for single misses: the keys missed within window on this node are
+// loaded with one GetMany call of up to maxKeys keys. window <= 0 calls
+// Get for every miss instead. 2ms and 100 keys by default
+func WithBatchWindow(window time.Duration, maxKeys int) GroupOption {
+	return func(g *Group) {
+		g.batchWindow = window
+		if maxKeys > 0 {
+			g.batchMaxKeys = maxKeys
+		}
+	}
+}
+
+// WithReplication keeps n copies of every value the Group loads locally:
+// the value is pushed asynchronously to the next n-1 owners of the key on
+// the ring, so that they serve it warm once the owner fails. Set and Remove
+// update those copies as well. n <= 1 disables replication. It needs a
+// PeerPicker which implements ReplicaPicker
+func WithReplication(n int) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+	}
+}
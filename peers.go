@@ -1,17 +1,299 @@
-package main
+package geecache
 
-import pb "geeCache/cachepb"
+import (
+	"context"
+	pb "github.com/yuyuyu258963/geeCache/cachepb"
+	"github.com/yuyuyu258963/geeCache/consistenthash"
+	"slices"
+)
 
 // PeerPicker is the interface that ,ust be implemented to locate
 // the peer that owns a specific key
 // 接口需要实现传入key选择相应的节点
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// GetAll returns all the remote peers, used to broadcast invalidations
+	GetAll() []PeerGetter
+}
+
+// ReplicaPicker is a PeerPicker which also knows the backup owners of a key
+type ReplicaPicker interface {
+	PeerPicker
+	// PickPeers returns up to n distinct owners of key in preference order.
+	// The list stops before self, since the local node then loads the key
+	// itself, so it is empty when self is the primary owner
+	PickPeers(key string, n int) []PeerGetter
+	// PickReplicas returns the peers among the first n owners of key
+	// other than self, the ones a value loaded by self is pushed to
+	PickReplicas(key string, n int) []PeerGetter
+	// PickOwner is like PickPeer but ignores the in-flight loads which a
+	// bounded-load placement balances reads with, so that Set and Remove
+	// always reach the node owning key on the ring
+	PickOwner(key string) (peer PeerGetter, ok bool)
 }
 
 // PeerGetter is the interface that must be implement by a peer
 // 接口PeerGetter的Get方法用于从对应的group查找缓存值
 type PeerGetter interface {
 	// Get(group string, key string) ([]byte, error)
-	Get(in *pb.Request, out *pb.Response) error
+	// ctx 的截止时间和取消会传递到远端请求上
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// Set 将key的新值写入远端节点的缓存
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// Remove 从远端节点的缓存中删除key
+	Remove(ctx context.Context, in *pb.Request) error
+	// Push 将本机加载到的值作为副本写入远端节点的缓存
+	Push(ctx context.Context, in *pb.SetRequest) error
+	// GetMany 在一次请求中查找多个key，加载失败的key记录在out.Errors中
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// Peer describes a peer and its weight on the hash ring
+type Peer struct {
+	Addr   string
+	Weight int // 节点的虚拟节点数为 Weight 倍，小于1时按1处理
+}
+
+// 将没有权重的节点地址转为权重为1的Peer
+func peersOf(addrs []string) []Peer {
+	peers := make([]Peer, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = Peer{Addr: addr, Weight: 1}
+	}
+	return peers
+}
+
+// 默认使用虚拟节点的一致性哈希环
+func defaultPlacement() consistenthash.Placement {
+	return consistenthash.New(defaultReplicas, nil)
+}
+
+// peerView 是节点集合在某一时刻的快照，创建之后不再修改
+// 节点变化时生成新的快照并原子地替换，PickPeer 读取快照时不需要加锁
+type peerView[G PeerGetter] struct {
+	placement    consistenthash.Placement        // 决定key归属于哪个节点
+	newPlacement func() consistenthash.Placement // 生成新快照时创建同类的placement
+	getters      map[string]G                    // 节点地址到访问它的getter
+	// 交给Group使用的getter，placement需要知道节点负载时会包装一层来报告负载
+	peerGetters map[string]PeerGetter
+}
+
+func newPeerView[G PeerGetter](newPlacement func() consistenthash.Placement) *peerView[G] {
+	if newPlacement == nil {
+		newPlacement = defaultPlacement
+	}
+	return &peerView[G]{
+		placement:    newPlacement(),
+		newPlacement: newPlacement,
+		getters:      make(map[string]G),
+		peerGetters:  make(map[string]PeerGetter),
+	}
+}
+
+// 返回key的归属节点，没有节点或归属节点为本机时返回false
+func (v *peerView[G]) pick(self, key string) (PeerGetter, bool) {
+	peer := v.placement.Get(key)
+	if peer == "" || peer == self {
+		return nil, false
+	}
+	g, ok := v.peerGetters[peer]
+	return g, ok
+}
+
+// 返回key在环上的归属节点，不受bounded load下节点负载的影响，本机是归属节点时返回false
+// placement 不支持 GetN 时与pick相同
+func (v *peerView[G]) owner(self, key string) (PeerGetter, bool) {
+	mp, ok := v.placement.(consistenthash.MultiPlacement)
+	if !ok {
+		return v.pick(self, key)
+	}
+	nodes := mp.GetN(key, 1)
+	if len(nodes) == 0 || nodes[0] == self {
+		return nil, false
+	}
+	g, ok := v.peerGetters[nodes[0]]
+	return g, ok
+}
+
+// 按顺序返回key的前n个归属节点，遇到本机时停止
+// placement 不支持 GetN 时只返回主节点
+func (v *peerView[G]) pickN(self, key string, n int) []PeerGetter {
+	mp, ok := v.placement.(consistenthash.MultiPlacement)
+	if !ok {
+		if g, ok := v.pick(self, key); ok {
+			return []PeerGetter{g}
+		}
+		return nil
+	}
+
+	// GetN 不考虑负载，第一个节点以 Get 为准，bounded load 模式下它会跳过满载的节点，
+	// 与PickPeer的选择一致，其余节点按GetN的顺序作为备份
+	var peers []string
+	for _, peer := range append([]string{mp.Get(key)}, mp.GetN(key, n)...) {
+		if len(peers) < n && peer != "" && !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+
+	var getters []PeerGetter
+	for _, peer := range peers {
+		if peer == self {
+			break
+		}
+		if g, ok := v.peerGetters[peer]; ok {
+			getters = append(getters, g)
+		}
+	}
+	return getters
+}
+
+// 返回key的前n个归属节点中除本机以外的节点，与pickN不同，遇到本机时不会停止
+// placement 不支持 GetN 时没有可以写入副本的节点
+func (v *peerView[G]) replicas(self, key string, n int) []PeerGetter {
+	mp, ok := v.placement.(consistenthash.MultiPlacement)
+	if !ok {
+		return nil
+	}
+
+	var getters []PeerGetter
+	for _, peer := range mp.GetN(key, n) {
+		if peer == self {
+			continue
+		}
+		if g, ok := v.peerGetters[peer]; ok {
+			getters = append(getters, g)
+		}
+	}
+	return getters
+}
+
+// 返回除本机以外所有节点的getter
+func (v *peerView[G]) all(self string) []PeerGetter {
+	getters := make([]PeerGetter, 0, len(v.peerGetters))
+	for peer, g := range v.peerGetters {
+		if peer == self {
+			continue
+		}
+		getters = append(getters, g)
+	}
+	return getters
+}
+
+// with 返回节点集合为peers的新快照，仍在集合中的节点沿用原来的getter
+// stale 为被移除节点的getter，由调用者负责释放
+func (v *peerView[G]) with(peers []Peer, newGetter func(string) (G, error)) (next *peerView[G], stale []G, err error) {
+	next = newPeerView[G](v.newPlacement)
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer.Addr] = peer.Weight
+		if _, ok := next.getters[peer.Addr]; ok {
+			continue
+		}
+		g, ok := v.getters[peer.Addr]
+		if !ok {
+			if g, err = newGetter(peer.Addr); err != nil {
+				return nil, nil, err
+			}
+		}
+		next.getters[peer.Addr] = g
+	}
+	next.placement.SetWeighted(weights)
+	next.wrapGetters()
+
+	for peer, g := range v.getters {
+		if _, ok := next.getters[peer]; !ok {
+			stale = append(stale, g)
+		}
+	}
+	return next, stale, nil
+}
+
+// 生成交给Group使用的getter
+func (v *peerView[G]) wrapGetters() {
+	reporter, ok := v.placement.(consistenthash.LoadReporter)
+	for peer, g := range v.getters {
+		if ok {
+			v.peerGetters[peer] = &loadReportingGetter{PeerGetter: g, peer: peer, reporter: reporter}
+		} else {
+			v.peerGetters[peer] = g
+		}
+	}
+}
+
+// without 返回去掉了peers的新快照
+func (v *peerView[G]) without(peers []string) (*peerView[G], []G) {
+	removed := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		removed[peer] = true
+	}
+	var rest []Peer
+	for _, peer := range v.peers() {
+		if !removed[peer.Addr] {
+			rest = append(rest, peer)
+		}
+	}
+	next, stale, _ := v.with(rest, func(string) (G, error) {
+		panic("without never adds a peer")
+	})
+	return next, stale
+}
+
+// 当前的节点及其权重
+func (v *peerView[G]) peers() []Peer {
+	nodes := v.placement.Nodes()
+	peers := make([]Peer, len(nodes))
+	for i, node := range nodes {
+		peers[i] = Peer{Addr: node, Weight: v.placement.Weight(node)}
+	}
+	return peers
+}
+
+// withPlacement 返回节点不变、但使用newPlacement决定key归属的新快照
+func (v *peerView[G]) withPlacement(newPlacement func() consistenthash.Placement) *peerView[G] {
+	if newPlacement == nil {
+		newPlacement = defaultPlacement
+	}
+	next := &peerView[G]{getters: v.getters, newPlacement: newPlacement}
+	next, _, _ = next.with(v.peers(), func(string) (G, error) {
+		panic("withPlacement never adds a peer")
+	})
+	return next
+}
+
+// loadReportingGetter 在每次请求远端节点的前后向placement报告节点的负载，
+// 用于 consistenthash.Map 的 bounded load 模式
+type loadReportingGetter struct {
+	PeerGetter
+	peer     string
+	reporter consistenthash.LoadReporter
+}
+
+func (g *loadReportingGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	g.reporter.Inc(g.peer)
+	defer g.reporter.Done(g.peer)
+	return g.PeerGetter.Get(ctx, in, out)
+}
+
+func (g *loadReportingGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	g.reporter.Inc(g.peer)
+	defer g.reporter.Done(g.peer)
+	return g.PeerGetter.Set(ctx, in)
+}
+
+func (g *loadReportingGetter) Remove(ctx context.Context, in *pb.Request) error {
+	g.reporter.Inc(g.peer)
+	defer g.reporter.Done(g.peer)
+	return g.PeerGetter.Remove(ctx, in)
+}
+
+func (g *loadReportingGetter) Push(ctx context.Context, in *pb.SetRequest) error {
+	g.reporter.Inc(g.peer)
+	defer g.reporter.Done(g.peer)
+	return g.PeerGetter.Push(ctx, in)
+}
+
+func (g *loadReportingGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	g.reporter.Inc(g.peer)
+	defer g.reporter.Done(g.peer)
+	return g.PeerGetter.GetMany(ctx, in, out)
 }
@@ -1,12 +1,18 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
-// 用来代表正在进行中，或已经结束的请求。使用wait.Group避免重入
+// 用来代表正在进行中，或已经结束的请求。使用done通道避免重入
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // 请求结束后关闭
+	val  interface{}
+	err  error
+
+	waiters int                // 还在等待这个请求结果的调用者数量
+	cancel  context.CancelFunc // 所有调用者都放弃等待时取消请求，Do发起的请求为nil
 }
 
 // 管理不同key的请求（call）
@@ -25,23 +31,161 @@ func (g *Group) Do(key string,
 	}
 
 	if c, ok := g.m[key]; ok { // 已经存在这个请求
+		c.waiters++
 		g.mu.Unlock()
-		c.wg.Wait()
+		<-c.done
 		return c.val, c.err
 	}
 
-	c := new(call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{}), waiters: 1}
 	g.m[key] = c
 	g.mu.Unlock() // 此时已经暂时完成了对g上数据结构体的访问
 
 	//后面执行fn获取数据的时间可能比较长，所以得先Unlock一下
+	g.finish(key, c, fn)
+	return c.val, c.err
+}
+
+// DoContext is like Do but a caller stops waiting once its ctx is done.
+// The shared call runs in its own goroutine with a context that keeps the
+// values of the first caller's ctx and is only cancelled after every caller
+// waiting for it has given up, so one impatient caller never fails the
+// load for the others
+func (g *Group) DoContext(ctx context.Context, key string,
+	fn func(context.Context) (interface{}, error)) (interface{}, error) {
+
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+
+	c, ok := g.m[key]
+	if ok { // 已经存在这个请求
+		c.waiters++
+		g.mu.Unlock()
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.m[key] = c
+		g.mu.Unlock()
+
+		go func() {
+			defer cancel()
+			g.finish(key, c, func() (interface{}, error) { return fn(callCtx) })
+		}()
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return nil, ctx.Err()
+	}
+}
+
+// 执行fn并唤醒所有等待的调用者
+func (g *Group) finish(key string, c *call, fn func() (interface{}, error)) {
 	c.val, c.err = fn()
-	c.wg.Done()
 
 	g.mu.Lock()
-	delete(g.m, key)
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
 	g.mu.Unlock()
+	close(c.done)
+}
 
-	return c.val, c.err
+// 调用者放弃等待，如果已经没有人等待这个请求就取消它
+func (g *Group) leave(key string, c *call) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 || c.cancel == nil {
+		return
+	}
+	c.cancel()
+	// 被取消的请求不能再被新的调用者复用
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+}
+
+// Result is the outcome of one key of DoMany
+type Result struct {
+	Val interface{}
+	Err error
+}
+
+// DoMany is like DoContext for a batch of keys. Keys which already have a
+// call in flight wait for it, fn is called once in its own goroutine with
+// all the other keys and must return a Result for each of them. Callers of
+// those keys through Do, DoContext or DoMany share the results, and the
+// batch is only cancelled after every caller waiting for one of its keys
+// has given up
+func (g *Group) DoMany(ctx context.Context, keys []string,
+	fn func(context.Context, []string) map[string]Result) map[string]Result {
+
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+
+	calls := make(map[string]*call, len(keys))
+	var leaders []string // 由这次调用发起请求的key
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+		if c, ok := g.m[key]; ok { // 已经存在这个请求
+			c.waiters++
+			calls[key] = c
+			continue
+		}
+		c := &call{done: make(chan struct{}), waiters: 1}
+		g.m[key] = c
+		calls[key] = c
+		leaders = append(leaders, key)
+	}
+
+	if len(leaders) > 0 {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		// 每个key的调用者都放弃等待时取消一次，所有key都被放弃后取消整个批量请求
+		// 在leave中调用，由g.mu保护
+		abandoned := 0
+		for _, key := range leaders {
+			calls[key].cancel = func() {
+				abandoned++
+				if abandoned == len(leaders) {
+					cancel()
+				}
+			}
+		}
+		go func() {
+			defer cancel()
+			results := fn(callCtx, leaders)
+			for _, key := range leaders {
+				r := results[key]
+				g.finish(key, calls[key], func() (interface{}, error) { return r.Val, r.Err })
+			}
+		}()
+	}
+	g.mu.Unlock()
+
+	results := make(map[string]Result, len(calls))
+	for key, c := range calls {
+		select {
+		case <-c.done:
+			results[key] = Result{Val: c.val, Err: c.err}
+		case <-ctx.Done():
+			for key, c := range calls {
+				if _, ok := results[key]; !ok {
+					g.leave(key, c)
+					results[key] = Result{Err: ctx.Err()}
+				}
+			}
+			return results
+		}
+	}
+	return results
 }
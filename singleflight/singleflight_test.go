@@ -0,0 +1,181 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "bar", nil
+			})
+			if err != nil || v.(string) != "bar" {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("fn called %d times, expected 1", n)
+	}
+}
+
+func TestDoContextWaiterGivesUp(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return "bar", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	impatient := make(chan error, 1)
+	go func() {
+		_, err := g.DoContext(ctx, "key", fn)
+		impatient <- err
+	}()
+	<-started
+
+	patient := make(chan interface{}, 1)
+	go func() {
+		v, _ := g.DoContext(context.Background(), "key", fn)
+		patient <- v
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-impatient; err != context.Canceled {
+		t.Fatalf("impatient caller got %v, expected %v", err, context.Canceled)
+	}
+
+	// 另一个调用者仍在等待，所以请求不应被取消
+	close(release)
+	if v := <-patient; v != "bar" {
+		t.Fatalf("patient caller got %v, expected bar", v)
+	}
+}
+
+func TestDoContextCancelWhenAllLeave(t *testing.T) {
+	var g Group
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("shared call was not cancelled after every caller left")
+	}
+}
+
+func TestDoMany(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	inflight := make(chan interface{})
+	go func() {
+		v, _ := g.Do("a", func() (interface{}, error) {
+			<-release
+			return "a-single", nil
+		})
+		inflight <- v
+	}()
+	waitCall(&g, "a")
+
+	var batches [][]string
+	started := make(chan struct{})
+	done := make(chan map[string]Result)
+	go func() {
+		done <- g.DoMany(context.Background(), []string{"a", "b", "c", "b"},
+			func(ctx context.Context, keys []string) map[string]Result {
+				batches = append(batches, keys)
+				close(started)
+				results := make(map[string]Result)
+				for _, key := range keys {
+					results[key] = Result{Val: key + "-batch"}
+				}
+				return results
+			})
+	}()
+
+	<-started // 批量请求已经开始，a仍在等待单独的请求
+	close(release)
+	results := <-done
+	<-inflight
+
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0] != "b" || batches[0][1] != "c" {
+		t.Fatalf("expected one batch of b and c, got %v", batches)
+	}
+	for key, want := range map[string]string{"a": "a-single", "b": "b-batch", "c": "c-batch"} {
+		if r := results[key]; r.Err != nil || r.Val != want {
+			t.Fatalf("%s: expected %s, got %v (err %v)", key, want, r.Val, r.Err)
+		}
+	}
+}
+
+func TestDoManyCancelWhenAllLeave(t *testing.T) {
+	var g Group
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	results := g.DoMany(ctx, []string{"a", "b"}, func(ctx context.Context, keys []string) map[string]Result {
+		<-ctx.Done()
+		close(cancelled)
+		return nil
+	})
+	if results["a"].Err != context.Canceled || results["b"].Err != context.Canceled {
+		t.Fatalf("expected both keys cancelled, got %v", results)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("the batch should be cancelled once every caller left")
+	}
+}
+
+// 等待key的请求开始
+func waitCall(g *Group, key string) {
+	for {
+		g.mu.Lock()
+		_, ok := g.m[key]
+		g.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,116 @@
+package geecache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// An AtomicInt is an int64 to be accessed atomically
+type AtomicInt int64
+
+// Add atomically adds n to i
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats are per-group statistics
+type Stats struct {
+	Gets      AtomicInt // any Get request, including from peers
+	CacheHits AtomicInt // either cache was good
+	// gets answered with ErrNotFound because the key was recently missing
+	NegativeHits AtomicInt
+	// gets rejected by the Bloom filter because the key does not exist
+	BloomRejects AtomicInt
+	// gets which passed the Bloom filter of this node but found the key
+	// does not exist
+	BloomFalsePositives AtomicInt
+	StaleHits           AtomicInt // gets served an expired value within maxStale
+	PeerLoads           AtomicInt // either remote load or remote cache hit (not an error)
+	PeerErrors          AtomicInt
+	PeerRetries         AtomicInt // peer requests sent again after a failure
+	// peer requests not sent because the circuit breaker of the peer was open
+	PeerCircuitOpen AtomicInt
+	HedgedRequests  AtomicInt // second requests sent because the owner was slow
+	HedgeWins       AtomicInt // hedged requests which answered first
+	Loads           AtomicInt // (gets - cacheHits)
+	LoadsDeduped    AtomicInt // after singleflight
+	LocalLoads      AtomicInt // total good local loads
+	LocalLoadErrs   AtomicInt // total bad local loads
+	BatchLoads      AtomicInt // GetMany calls of a BatchGetter
+	NotFound        AtomicInt // loads which found the key does not exist
+	ServerRequests  AtomicInt // gets that came over the network from peers
+	Refreshes       AtomicInt // background reloads of stale or aging entries
+	RefreshErrs     AtomicInt // failed background reloads
+
+	ReplicaPushes    AtomicInt // values pushed to the other owners of a key
+	ReplicaPushErrs  AtomicInt // failed pushes to the other owners
+	ReplicasReceived AtomicInt // values pushed here by another owner
+}
+
+// 读取每个计数当前的值，返回一份快照
+func (s *Stats) snapshot() Stats {
+	var c Stats
+	c.Gets.Add(s.Gets.Get())
+	c.CacheHits.Add(s.CacheHits.Get())
+	c.NegativeHits.Add(s.NegativeHits.Get())
+	c.BloomRejects.Add(s.BloomRejects.Get())
+	c.BloomFalsePositives.Add(s.BloomFalsePositives.Get())
+	c.StaleHits.Add(s.StaleHits.Get())
+	c.PeerLoads.Add(s.PeerLoads.Get())
+	c.PeerErrors.Add(s.PeerErrors.Get())
+	c.PeerRetries.Add(s.PeerRetries.Get())
+	c.PeerCircuitOpen.Add(s.PeerCircuitOpen.Get())
+	c.HedgedRequests.Add(s.HedgedRequests.Get())
+	c.HedgeWins.Add(s.HedgeWins.Get())
+	c.Loads.Add(s.Loads.Get())
+	c.LoadsDeduped.Add(s.LoadsDeduped.Get())
+	c.LocalLoads.Add(s.LocalLoads.Get())
+	c.LocalLoadErrs.Add(s.LocalLoadErrs.Get())
+	c.BatchLoads.Add(s.BatchLoads.Get())
+	c.NotFound.Add(s.NotFound.Get())
+	c.ServerRequests.Add(s.ServerRequests.Get())
+	c.Refreshes.Add(s.Refreshes.Get())
+	c.RefreshErrs.Add(s.RefreshErrs.Get())
+	c.ReplicaPushes.Add(s.ReplicaPushes.Get())
+	c.ReplicaPushErrs.Add(s.ReplicaPushErrs.Get())
+	c.ReplicasReceived.Add(s.ReplicasReceived.Get())
+	return c
+}
+
+// CacheType represents a type of cache
+type CacheType int
+
+const (
+	// MainCache is the cache for items that this peer is the owner for
+	MainCache CacheType = iota + 1
+
+	// HotCache is the cache for items that seem popular enough to
+	// replicate to this node, even though it's not the owner
+	HotCache
+)
+
+// Stats returns a snapshot of the group's statistics
+func (g *Group) Stats() Stats {
+	return g.stats.snapshot()
+}
+
+// CacheStats returns stats about the provided cache within the group
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
+}